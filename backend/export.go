@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/Ricefrog/fractalHeaven/render"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exports are computed at up to this many pixels square.
+const MaxExportSize = 4096
+
+type exportStruct struct {
+	Filename string `json:"filename"`
+	// Base64 encoded file.
+	Data string `json:"data"`
+	// Sidecar describing raw exports.
+	Header *render.RawHeader `json:"header,omitempty"`
+}
+
+// The compute stage for s, and the colouring it uses by default.
+func sampleFunc(s requestStruct) (render.SampleFunc, string, error) {
+	switch {
+	case render.IsVariant(s.FractalType):
+		return render.GetSampleFunc(s.FractalType), "escape", nil
+	case s.FractalType == "multibrot":
+		k := complex(s.Cr, s.Ci)
+		return render.GetMultibrotSampleFunc(multibrotPower(s), s.Julia, k),
+			"smooth", nil
+	case s.FractalType == "newton":
+		system := newtonSystem(s.FunctionToUse)
+		return system.Sample(), "escape", nil
+	}
+	return nil, "", fmt.Errorf("no raw data for %s", s.FractalType)
+}
+
+// Runs the compute stage for s at size pixels square. Escape-time
+// variants are computed in the tier precision picks, as renders are.
+func computeBuffer(s requestStruct, size int) (*render.Buffer, error) {
+	sample, _, err := sampleFunc(s)
+	if err != nil {
+		return nil, err
+	}
+	if render.IsVariant(s.FractalType) {
+		if tier, prec := precision(s, size); tier != render.PrecisionFloat64 {
+			f, _ := frameHP(s, prec)
+			a := render.NewArithmetic(tier, f)
+			log.Printf("Computing %s at %dx%d (%s, %d bits).\n",
+				s.FractalType, size, size, tier, prec)
+			sample := render.GetSampleFuncHP(a, s.FractalType)
+			return <-render.RenderBufferHP(size, size, f, sample), nil
+		}
+	}
+	log.Printf("Computing %s at %dx%d.\n", s.FractalType, size, size)
+	return <-render.RenderBuffer(size, size, frame(s), sample), nil
+}
+
+// The size s is exported at in format, checked against the limits and
+// the formats before anything is computed.
+func exportSize(s requestStruct, format string) (int, error) {
+	size := s.ExportSize
+	if size <= 0 {
+		size = WIDTH
+	}
+	if size > MaxExportSize {
+		return 0, fmt.Errorf(
+			"export size %d is over the limit of %d", size, MaxExportSize,
+		)
+	}
+	switch format {
+	case "npy", "raw":
+		return size, nil
+	case "csv":
+		return size, render.CheckCSVSize(size, size)
+	}
+	return 0, fmt.Errorf("unknown export format %q", format)
+}
+
+func writeExport(w io.Writer, format string, b *render.Buffer) error {
+	switch format {
+	case "npy":
+		return render.WriteNPY(w, b)
+	case "raw":
+		return render.WriteRaw(w, b)
+	case "csv":
+		return render.WriteCSV(w, b)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// Exports the frame of the request at requestPath to path, in the format
+// named by its extension. Raw exports get a .json sidecar next to them.
+func exportCLI(requestPath, path string) error {
+	var in io.Reader = os.Stdin
+	if requestPath != "-" {
+		file, err := os.Open(requestPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	var s requestStruct
+	if err := json.NewDecoder(in).Decode(&s); err != nil {
+		return err
+	}
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	size, err := exportSize(s, format)
+	if err != nil {
+		return err
+	}
+	b, err := computeBuffer(s, size)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeExport(out, format, b)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || format != "raw" {
+		return err
+	}
+	header, err := json.MarshalIndent(render.NewRawHeader(b), "", "  ")
+	if err != nil {
+		return err
+	}
+	sidecar := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	return os.WriteFile(sidecar, header, 0644)
+}
+
+func export(w http.ResponseWriter, r *http.Request) {
+	log.Println("export received request.")
+
+	s, ok := decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	format := s.ExportFormat
+	if format == "" {
+		format = "npy"
+	}
+
+	start := time.Now()
+	var err error
+	var b *render.Buffer
+	if s.Handle != "" {
+		b, _, err = store.get(s.Handle)
+		if err != nil {
+			w.WriteHeader(404)
+			log.Print(err)
+			return
+		}
+	} else {
+		var size int
+		if size, err = exportSize(s, format); err != nil {
+			w.WriteHeader(400)
+			log.Print(err)
+			return
+		}
+		if size == WIDTH {
+			// Frames at the usual size are shared with renders.
+			b, _, _, err = frameBuffer(s)
+		} else {
+			b, err = computeBuffer(s, size)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(400)
+		log.Print(err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeExport(buf, format, b); err != nil {
+		w.WriteHeader(400)
+		log.Print(err)
+		return
+	}
+	res := exportStruct{
+		Filename: "frame." + format,
+		Data:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}
+	if format == "raw" {
+		header := render.NewRawHeader(b)
+		res.Header = &header
+	}
+	log.Printf("Frame exported. %f s.\n", time.Since(start).Seconds())
+	writeJSON(w, res)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Exports over the limits are refused before anything is computed.
+func TestExportRejects(t *testing.T) {
+	tests := map[string]string{
+		"too large": `{"fractalType": "mandelbrot", "exportSize": 100000}`,
+		"csv": `{"fractalType": "mandelbrot", "exportFormat": "csv",
+			"exportSize": 1024}`,
+		"csv by default": `{"fractalType": "mandelbrot", "exportFormat": "csv"}`,
+		"format": `{"fractalType": "mandelbrot", "exportFormat": "png",
+			"exportSize": 2048}`,
+	}
+	for name, body := range tests {
+		w := httptest.NewRecorder()
+		export(w, httptest.NewRequest("POST", "/api/export", strings.NewReader(body)))
+		if w.Code != 400 {
+			t.Errorf("%s: status %d, want 400", name, w.Code)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/Ricefrog/fractalHeaven/render"
 	"github.com/rs/cors"
 	"image"
@@ -12,6 +14,10 @@ import (
 	"log"
 	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,6 +27,9 @@ const (
 )
 
 type requestStruct struct {
+	// JSON numbers or decimal strings. Strings keep every digit for high
+	// precision renders, which read them from xText, yText and zoomText;
+	// everything else uses them rounded to float64.
 	X             float64 `json:"x"`
 	Y             float64 `json:"y"`
 	Zoom          float64 `json:"zoom"`
@@ -28,7 +37,193 @@ type requestStruct struct {
 	FunctionToUse string  `json:"functionToUse"`
 	Colorized     bool    `json:"colorized"`
 	AntiAliasing  bool    `json:"antiAliasing"`
-	HighPrecision bool    `json:"highPrecision"`
+	// Escape-time renders pick their precision from the zoom.
+	// HighPrecision skips float64 however shallow the frame is.
+	HighPrecision bool `json:"highPrecision"`
+	// Supersampling options for AntiAliasing. AASamples defaults to 4 and
+	// takes up to 64. AAPattern is "grid", "rotated", "jittered" or
+	// "poisson"; AAFilter is "box", "tent", "gaussian" or "lanczos".
+	AASamples int    `json:"aaSamples"`
+	AAPattern string `json:"aaPattern"`
+	AAFilter  string `json:"aaFilter"`
+	// AA only supersamples pixels that differ from a neighbour by more
+	// than AAThreshold (0 to 1, default 0.05), refining up to AADepth
+	// levels (default 2). AAUniform supersamples every pixel instead,
+	// for palettes that paint neighbouring bands alike.
+	AAUniform   bool    `json:"aaUniform"`
+	AAThreshold float64 `json:"aaThreshold"`
+	AADepth     int     `json:"aaDepth"`
+	// Julia-style renders start the orbit at the pixel and use the
+	// constant (Cr, Ci) in place of the pixel.
+	Julia bool    `json:"julia"`
+	Cr    float64 `json:"cr"`
+	Ci    float64 `json:"ci"`
+	// Exponent for multibrot renders. Defaults to 2.
+	Power     float64 `json:"power"`
+	PowerImag float64 `json:"powerImag"`
+	// Weight of the previous orbit value in phoenix renders. (Cr, Ci)
+	// supplies p.
+	Qr float64 `json:"qr"`
+	Qi float64 `json:"qi"`
+	// A/B sequence driving lyapunov renders. Defaults to "AB".
+	Sequence string `json:"sequence"`
+	// Buddhabrot options. Nebula renders use fixed limits per channel.
+	// Samples and Seed also drive the chaos game in ifs and flame renders.
+	Samples    int   `json:"samples"`
+	Iterations int   `json:"iterations"`
+	Nebula     bool  `json:"nebula"`
+	Anti       bool  `json:"anti"`
+	Seed       int64 `json:"seed"`
+	// IFS options. IFSMaps takes precedence over IFSPreset.
+	IFSPreset  string             `json:"ifsPreset"`
+	IFSMaps    []render.AffineMap `json:"ifsMaps"`
+	LogDensity bool               `json:"logDensity"`
+	// Flame definition, either as JSON or in the .flame XML format.
+	Flame    *render.Flame `json:"flame"`
+	FlameXML string        `json:"flameXML"`
+	// L-system definition. LSystem takes precedence over LSystemPreset,
+	// and Depth overrides the depth of either when set.
+	LSystemPreset string          `json:"lsystemPreset"`
+	LSystem       *render.LSystem `json:"lsystem"`
+	Depth         int             `json:"depth"`
+	// Output format, "jpeg" (the default) or "svg". Escape-time renders
+	// are traced into Levels contour lines for svg output.
+	Format string `json:"format"`
+	Levels int    `json:"levels"`
+	// Colouring mode: "escape" (the default), "distance", which draws
+	// the boundary of mandelbrot and multibrot sets Thickness pixels wide,
+	// "trap", which colours escape-time and newton renders by how close
+	// each orbit comes to Trap, or one of the orbit averages "stripe",
+	// "tia" and "curvature" for escape-time renders. Stripes sets the
+	// stripe density and defaults to 5. "histogram" spreads the palette
+	// evenly over the frame in escape-time and newton renders, "smooth"
+	// blends the escape bands and "root" colours newton renders by root.
+	Coloring  string       `json:"coloring"`
+	Thickness float64      `json:"thickness"`
+	Trap      *render.Trap `json:"trap"`
+	Stripes   float64      `json:"stripes"`
+	// Colouring for the inside of mandelbrot renders: "modulus",
+	// "period", "distance" or "atom". Black when unset.
+	Interior string `json:"interior"`
+	// Frame to recolour or export, as returned by an earlier render.
+	Handle string `json:"handle"`
+	// Raw data export: "npy", "raw" or "csv", at ExportSize pixels
+	// square. Defaults to the size of renders.
+	ExportFormat string `json:"exportFormat"`
+	ExportSize   int    `json:"exportSize"`
+	// Shade the render as a lit surface. LightAngle is in degrees
+	// counter-clockwise from the right of the image.
+	Lighting    bool    `json:"lighting"`
+	LightAngle  float64 `json:"lightAngle"`
+	LightHeight float64 `json:"lightHeight"`
+	Ambient     float64 `json:"ambient"`
+	Specular    float64 `json:"specular"`
+
+	xText, yText, zoomText string
+}
+
+// A field of a request that is not a decimal number, which is the
+// client's mistake rather than the server's.
+type badNumberError struct {
+	field, text string
+}
+
+func (e *badNumberError) Error() string {
+	return fmt.Sprintf("%s is not a decimal number: %q", e.field, e.text)
+}
+
+// Returns the text of a JSON number or decimal string. Empty strings and
+// null read as 0, as they did when the fields were plain numbers.
+func decimalText(field string, raw json.RawMessage) (string, error) {
+	text := string(raw)
+	if text == "" || text == "null" {
+		return "0", nil
+	}
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", err
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return "0", nil
+		}
+	}
+	// High precision renders parse the text again with every digit.
+	if v, ok := new(big.Float).SetString(text); !ok || v.IsInf() {
+		return "", &badNumberError{field, text}
+	}
+	return text, nil
+}
+
+func (s *requestStruct) UnmarshalJSON(data []byte) error {
+	// plain has the fields of requestStruct without this method, and the
+	// fields below take the place of its X, Y and Zoom.
+	type plain requestStruct
+	var r struct {
+		plain
+		X    json.RawMessage `json:"x"`
+		Y    json.RawMessage `json:"y"`
+		Zoom json.RawMessage `json:"zoom"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*s = requestStruct(r.plain)
+	fields := []struct {
+		name  string
+		raw   json.RawMessage
+		text  *string
+		value *float64
+	}{
+		{"x", r.X, &s.xText, &s.X},
+		{"y", r.Y, &s.yText, &s.Y},
+		{"zoom", r.Zoom, &s.zoomText, &s.Zoom},
+	}
+	for _, f := range fields {
+		text, err := decimalText(f.name, f.raw)
+		if err != nil {
+			return err
+		}
+		*f.text = text
+		// Values too large for float64 become infinite. They are only of
+		// use at high precision.
+		*f.value, _ = strconv.ParseFloat(text, 64)
+	}
+	return nil
+}
+
+// Decodes the request in the body of r, answering w with 400 for
+// requests that are not valid and 500 for anything else.
+func decodeRequest(w http.ResponseWriter, r *http.Request) (requestStruct, bool) {
+	var s requestStruct
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err == nil {
+		return s, true
+	}
+	var bad *badNumberError
+	var syntax *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &bad) || errors.As(err, &syntax) ||
+		errors.As(err, &typeErr) {
+		w.WriteHeader(400)
+	} else {
+		w.WriteHeader(500)
+	}
+	log.Print(err)
+	return s, false
+}
+
+// Returns the centre and zoom of s at prec bits, from every digit they
+// were sent with. The centre is flipped into render coordinates.
+func bigFrame(s requestStruct, prec uint) (cx, cy, zoom *big.Float) {
+	parse := func(text string) *big.Float {
+		// Checked when the request was decoded.
+		v, _ := new(big.Float).SetPrec(prec).SetString(text)
+		return v
+	}
+	// Subtracted rather than negated, which would give -0 for 0.
+	cy = new(big.Float).Sub(new(big.Float), parse(s.yText))
+	return parse(s.xText), cy, parse(s.zoomText)
 }
 
 type responseStruct struct {
@@ -39,12 +234,40 @@ type responseStruct struct {
 	YMin   float64 `json:"ymin"`
 	Cx     float64 `json:"x"`
 	Cy     float64 `json:"y"`
+	// Set instead of Base64 for svg output.
+	SVG string `json:"svg,omitempty"`
+	// Set when the frame can be recoloured through /api/recolor.
+	Handle string `json:"handle,omitempty"`
+	// The arithmetic escape-time renders used, "float64",
+	// "doubledouble", "extended" or "bigfloat", and its bits of mantissa.
+	Precision     string `json:"precision,omitempty"`
+	PrecisionBits uint   `json:"precisionBits,omitempty"`
+	// Set for high precision frames, whose bounds the fields above can
+	// only approximate.
+	Exact *exactBounds `json:"exact,omitempty"`
+}
+
+// The frame of a response as decimal strings, to every digit it was
+// rendered at. X and Y are flipped like Cx and Cy.
+type exactBounds struct {
+	XMax string `json:"xmax"`
+	XMin string `json:"xmin"`
+	YMax string `json:"ymax"`
+	YMin string `json:"ymin"`
+	Cx   string `json:"x"`
+	Cy   string `json:"y"`
+	Zoom string `json:"zoom"`
 }
 
 var t bool
+var exportPath, requestPath string
 
 func init() {
 	flag.BoolVar(&t, "t", false, "Run test stub.")
+	flag.StringVar(&exportPath, "export", "",
+		"Write the raw data of a render to this .npy, .raw or .csv file.")
+	flag.StringVar(&requestPath, "request", "-",
+		"JSON render request to export, or - for stdin.")
 }
 
 func main() {
@@ -53,45 +276,369 @@ func main() {
 		testStub()
 		return
 	}
+	if exportPath != "" {
+		if err := exportCLI(requestPath, exportPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/", helloWorld)
 	mux.HandleFunc("/api/renderFractal", renderFractal)
+	mux.HandleFunc("/api/recolor", recolor)
+	mux.HandleFunc("/api/export", export)
+
+	store = newBufferStore(filepath.Join(os.TempDir(), "fractalHeaven-buffers"))
 
 	log.Printf("Server started on port %s.\n", PORT)
 	handler := cors.Default().Handler(mux)
 	http.ListenAndServe(":8080", handler)
 }
 
-func helloWorld(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(200)
-	w.Write([]byte("hello world!"))
-	return
+// Renders the contour lines of v as svg.
+func renderContours(
+	s requestStruct, f render.FrameInfo, v render.ValueFunc,
+) (string, error) {
+	levels := s.Levels
+	if levels <= 0 {
+		levels = 16
+	}
+	if levels > render.MaxContourLevels {
+		return "", fmt.Errorf(
+			"contour levels %d is over the limit of %d",
+			levels, render.MaxContourLevels,
+		)
+	}
+	log.Printf("Tracing %d contour levels.\n", levels)
+	field := <-render.RenderField(WIDTH, HEIGHT, f, v)
+	return string(render.ContourSVG(field, levels, s.Colorized)), nil
 }
 
-func renderMandelbrot(s requestStruct) responseStruct {
-	log.Println("Rendering mandelbrot (regular precision).")
-	start := time.Now()
+// Defaults to 2.
+func multibrotPower(s requestStruct) complex128 {
+	power := complex(s.Power, s.PowerImag)
+	if power == 0 {
+		return 2
+	}
+	return power
+}
+
+// Falls back to f(z) = z^4 - 1 for unknown functions, in Newton and Nova
+// renders alike.
+func newtonSystem(functionToUse string) render.NewtonSystem {
+	switch functionToUse {
+	case "f(z) = z^4 - 1":
+		return render.NewtonSystemOne
+	case "f(z) = z^3 - 1":
+		return render.NewtonSystemTwo
+	case "f(z) = 5cos(3z)":
+		return render.NewtonSystemThree
+	case "f(z) = ln(z)":
+		return render.NewtonSystemFour
+	case "f(z) = z^3 - 1, a = 2":
+		return render.NewtonSystemFive
+	case "f(z) = cosh(z) - 1":
+		return render.NewtonSystemSix
+	default:
+		return render.NewtonSystemOne
+	}
+}
+
+func light(s requestStruct) render.Light {
+	height := s.LightHeight
+	if height <= 0 {
+		height = 1.5
+	}
+	return render.Light{
+		Angle:    s.LightAngle,
+		Height:   height,
+		Ambient:  s.Ambient,
+		Specular: s.Specular,
+	}
+}
+
+// The frame of s at regular precision.
+func frame(s requestStruct) render.FrameInfo {
 	cx, cy := s.X, -s.Y
 	boundary := 2.0 / s.Zoom
-	xmin, ymin := (cx - boundary), (cy - boundary)
-	xmax, ymax := (cx + boundary), (cy + boundary)
+	return render.ConstructFrameInfo(
+		boundary,
+		cx-boundary, cy-boundary,
+		cx+boundary, cy+boundary,
+		cx, cy,
+	)
+}
 
-	frameInfo := render.ConstructFrameInfo(
+// The frame of s at prec bits, and its zoom.
+func frameHP(s requestStruct, prec uint) (render.FrameInfoHP, *big.Float) {
+	cx, cy, zoom := bigFrame(s, prec)
+
+	// Distance from the center.
+	startBoundary := new(big.Float).SetPrec(prec).SetInt64(2)
+	boundary := new(big.Float).Quo(startBoundary, zoom)
+
+	xmin := new(big.Float).Sub(cx, boundary)
+	ymin := new(big.Float).Sub(cy, boundary)
+	xmax := new(big.Float).Add(cx, boundary)
+	ymax := new(big.Float).Add(cy, boundary)
+
+	frameInfo := render.ConstructFrameInfoHP(
 		boundary,
 		xmin, ymin,
 		xmax, ymax,
 		cx, cy,
 	)
+	return frameInfo, zoom
+}
+
+// Returns the precision tier for an escape-time render of s at width
+// pixels across and its bits of mantissa.
+func precision(s requestStruct, width int) (string, uint) {
+	_, _, zoom := bigFrame(s, 64)
+	pixelSize := new(big.Float).Mul(zoom, big.NewFloat(float64(width)/4))
+	pixelSize.Quo(big.NewFloat(1), pixelSize)
+	bits := render.PrecisionBits(s.X, s.Y, pixelSize)
+	if s.HighPrecision && bits <= render.Float64Bits {
+		// The cheapest tier past float64.
+		bits = render.Float64Bits + 1
+	}
+	return render.ChoosePrecision(bits, s.FractalType)
+}
+
+func aaOptions(s requestStruct) render.AAOptions {
+	o := render.DefaultAA
+	if s.AASamples > 0 {
+		o.Samples = s.AASamples
+	}
+	if s.AAPattern != "" {
+		o.Pattern = s.AAPattern
+	}
+	if s.AAFilter != "" {
+		o.Filter = s.AAFilter
+	}
+	if s.AAThreshold > 0 {
+		o.Threshold = s.AAThreshold
+	}
+	if s.AADepth > 0 {
+		o.Depth = s.AADepth
+	}
+	o.Adaptive = !s.AAUniform
+	o.Seed = s.Seed
+	return o
+}
+
+// Returns a distance estimating MandelFunc for z = z^d + c.
+func distanceFunc(
+	s requestStruct, d complex128, julia bool, boundary float64,
+) render.MandelFunc {
+	pixelSize := 2 * boundary / WIDTH
+	k := complex(s.Cr, s.Ci)
+	return render.GetDistanceFunc(
+		d, julia, k, pixelSize, thickness(s), s.Colorized,
+	)
+}
+
+func thickness(s requestStruct) float64 {
+	if s.Thickness <= 0 {
+		return 1
+	}
+	return s.Thickness
+}
+
+func isAverage(coloring string) bool {
+	return coloring == "stripe" || coloring == "tia" || coloring == "curvature"
+}
+
+func stripes(s requestStruct) float64 {
+	if s.Stripes <= 0 {
+		return 5
+	}
+	return s.Stripes
+}
+
+// Whether the colouring asked for can be done from a buffer.
+func buffered(s requestStruct) bool {
+	if s.AntiAliasing || s.Lighting {
+		return false
+	}
+	switch s.Interior {
+	case "", "modulus", "period":
+	default:
+		return false
+	}
+	switch s.Coloring {
+	case "", "escape", "smooth", "distance", "histogram", "root":
+		return true
+	}
+	return false
+}
+
+// Renders through the compute and colouring stages, reusing the stored
+// buffer for the frame if there is one. Returns the image and the handle
+// of the frame.
+func renderBuffered(s requestStruct) (image.Image, string, error) {
+	b, mode, handle, err := frameBuffer(s)
+	if err != nil {
+		return nil, "", err
+	}
+	return colorBuffer(s, b, mode), handle, nil
+}
+
+// Returns the buffer for the frame of s at the size of renders, computing
+// and storing it if there is none yet, with the colouring it uses when a
+// request does not name one and its handle.
+func frameBuffer(s requestStruct) (*render.Buffer, string, string, error) {
+	handle := frameHandle(s)
+	if b, mode, err := store.get(handle); err == nil {
+		log.Println("Using stored buffer.")
+		return b, mode, handle, nil
+	}
+	_, mode, err := sampleFunc(s)
+	if err != nil {
+		return nil, "", "", err
+	}
+	b, err := computeBuffer(s, WIDTH)
+	if err != nil {
+		return nil, "", "", err
+	}
+	store.put(handle, b, mode)
+	return b, mode, handle, nil
+}
+
+func colorBuffer(s requestStruct, b *render.Buffer, mode string) image.Image {
+	if s.Coloring != "" {
+		mode = s.Coloring
+	}
+	return render.ColorBuffer(b, render.Coloring{
+		Mode:      mode,
+		Interior:  s.Interior,
+		Thickness: s.Thickness,
+		Colorized: s.Colorized,
+	})
+}
+
+// Colours v by histogram equalisation. limit is the iteration limit of v.
+func renderEqualized(
+	f render.FrameInfo, v render.ValueFunc, limit float64, colorized bool,
+) image.Image {
+	log.Println("Using histogram equalisation.")
+	field := <-render.RenderField(WIDTH, HEIGHT, f, v)
+	return render.EqualizeField(field, limit, colorized)
+}
+
+func orbitTrap(s requestStruct) (*render.OrbitTrap, error) {
+	if s.Trap == nil {
+		return nil, fmt.Errorf("trap colouring needs a trap")
+	}
+	return render.NewOrbitTrap(*s.Trap)
+}
+
+// The response for a render of f, before the image is added.
+func frameResponse(f render.FrameInfo) responseStruct {
+	_, xmin, ymin, xmax, ymax, cx, cy := f.Read()
+	return responseStruct{
+		XMax: xmax,
+		XMin: xmin,
+		YMax: ymax,
+		YMin: ymin,
+		Cx:   cx,
+		Cy:   cy,
+	}
+}
+
+func encodeImage(img image.Image) string {
+	buf := new(bytes.Buffer)
+	jpeg.Encode(buf, img, nil)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(500)
+		log.Print(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func helloWorld(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(200)
+	w.Write([]byte("hello world!"))
+	return
+}
+
+func renderMandelbrot(s requestStruct) (responseStruct, error) {
+	log.Printf("Rendering %s (regular precision).\n", s.FractalType)
+	start := time.Now()
+	frameInfo := frame(s)
+	boundary, _, _, _, _, cx, cy := frameInfo.Read()
+
+	if s.Format == "svg" {
+		v := render.GetValueFunc(s.FractalType)
+		svg, err := renderContours(s, frameInfo, v)
+		if err != nil {
+			return responseStruct{}, err
+		}
+		log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+		resStruct := frameResponse(frameInfo)
+		resStruct.SVG = svg
+		resStruct.Precision = render.PrecisionFloat64
+		resStruct.PrecisionBits = render.Float64Bits
+		return resStruct, nil
+	}
 
 	var m render.MandelFunc
-	m = render.GetMandelFunc(s.Colorized)
+	if s.Coloring == "trap" {
+		log.Println("Using orbit trap.")
+		trap, err := orbitTrap(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+		m = render.GetTrapFunc(s.FractalType, trap, s.Colorized)
+	} else if isAverage(s.Coloring) {
+		log.Printf("Using %s average colouring.\n", s.Coloring)
+		m = render.GetAverageFunc(
+			s.FractalType, s.Coloring, stripes(s), s.Colorized,
+		)
+	} else if s.Lighting && s.FractalType == "mandelbrot" {
+		log.Println("Using lighting.")
+		m = render.GetLitDistanceFunc(2, false, 0, light(s), s.Colorized)
+	} else if s.Lighting {
+		log.Println("Using lighting.")
+		m = render.GetLitFunc(
+			render.GetValueFunc(s.FractalType),
+			render.GetMandelFunc(s.FractalType, s.Colorized),
+			light(s),
+			2*boundary/WIDTH,
+		)
+	} else if s.Coloring == "distance" && s.FractalType == "mandelbrot" {
+		log.Println("Using distance estimation.")
+		m = distanceFunc(s, 2, false, boundary)
+	} else if s.Interior != "" && s.FractalType == "mandelbrot" {
+		log.Printf("Using %s interior colouring.\n", s.Interior)
+		m = render.GetInteriorFunc(s.Interior, 2*boundary/WIDTH, s.Colorized)
+	} else {
+		m = render.GetMandelFunc(s.FractalType, s.Colorized)
+	}
 
 	log.Printf("Center: (%g, %g).\n", cx, cy)
 	var img image.Image
-	if s.AntiAliasing {
+	var handle string
+	var err error
+	if buffered(s) {
+		img, handle, err = renderBuffered(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+	} else if s.Coloring == "histogram" {
+		v := render.GetValueFunc(s.FractalType)
+		img = renderEqualized(frameInfo, v, 100, s.Colorized)
+	} else if s.AntiAliasing {
 		log.Println("Rendering with anti-aliasing.")
-		img = <-render.RenderMFrameAA(WIDTH, HEIGHT, frameInfo, m)
+		img = <-render.RenderMFrameAA(WIDTH, HEIGHT, frameInfo, m, aaOptions(s))
 	} else {
 		log.Println("Rendering without anti-aliasing.")
 		img = <-render.RenderMFrame(WIDTH, HEIGHT, frameInfo, m)
@@ -99,119 +646,176 @@ func renderMandelbrot(s requestStruct) responseStruct {
 
 	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
 
-	buf := new(bytes.Buffer)
-	jpeg.Encode(buf, img, nil)
-	encodedImage := base64.StdEncoding.EncodeToString(buf.Bytes())
-
-	resStruct := responseStruct{
-		Base64: encodedImage,
-		XMax:   xmax,
-		XMin:   xmin,
-		YMax:   ymax,
-		YMin:   ymin,
-		Cx:     cx,
-		Cy:     cy,
-	}
-	return resStruct
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	resStruct.Handle = handle
+	resStruct.Precision = render.PrecisionFloat64
+	resStruct.PrecisionBits = render.Float64Bits
+	return resStruct, nil
 }
 
-func renderMandelbrotHP(s requestStruct) responseStruct {
-	log.Println("Rendering mandelbrot (high-precision).")
+// tier is the arithmetic orbits are followed in and prec the precision of
+// the frame in bits. Everything computed from the frame inherits it.
+func renderMandelbrotHP(
+	s requestStruct, tier string, prec uint,
+) (responseStruct, error) {
+	log.Printf("Rendering %s (%s, %d bits).\n", s.FractalType, tier, prec)
 	start := time.Now()
-	cx, cy := big.NewFloat(s.X), big.NewFloat(-s.Y)
+	frameInfo, zoom := frameHP(s, prec)
+	boundary, xmin, ymin, xmax, ymax, cx, cy := frameInfo.Read()
 
-	// Distance from the center.
-	startBoundary, zoom := big.NewFloat(2.0), big.NewFloat(s.Zoom)
-	boundary := new(big.Float).Quo(startBoundary, zoom)
-
-	xmin := new(big.Float).Sub(cx, boundary)
-	ymin := new(big.Float).Sub(cy, boundary)
-	xmax := new(big.Float).Add(cx, boundary)
-	ymax := new(big.Float).Add(cy, boundary)
+	log.Printf("Center: (%s, %s).\n", render.BigPrint(cx), render.BigPrint(cy))
+	// The width of a pixel, exact for placing samples and rounded for
+	// shading.
+	pixelSize := new(big.Float).Quo(boundary, big.NewFloat(WIDTH/2))
+	pixelSize64, _ := pixelSize.Float64()
+	a := render.NewArithmetic(tier, frameInfo)
 
-	frameInfo := render.ConstructFrameInfoHP(
-		boundary,
-		xmin, ymin,
-		xmax, ymax,
-		cx, cy,
-	)
+	var m render.MandelFuncHP
+	if s.Coloring == "trap" {
+		log.Println("Using orbit trap.")
+		trap, err := orbitTrap(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+		m = render.GetTrapFuncHP(a, s.FractalType, trap, s.Colorized)
+	} else if isAverage(s.Coloring) {
+		log.Printf("Using %s average colouring.\n", s.Coloring)
+		m = render.GetAverageFuncHP(
+			a, s.FractalType, s.Coloring, stripes(s), s.Colorized,
+		)
+	} else if s.Lighting && s.FractalType == "mandelbrot" {
+		log.Println("Using lighting.")
+		m = render.GetLitDistanceFuncHP(a, light(s), s.Colorized)
+	} else if s.Lighting {
+		log.Println("Using lighting.")
+		m = render.GetLitFuncHP(
+			render.GetValueFuncHP(a, s.FractalType),
+			render.GetMandelFuncHP(a, s.FractalType, s.Colorized),
+			light(s),
+			pixelSize,
+		)
+	} else if s.Coloring == "distance" && s.FractalType == "mandelbrot" {
+		log.Println("Using distance estimation.")
+		m = render.GetDistanceFuncHP(
+			a, pixelSize64, thickness(s), s.Colorized,
+		)
+	} else if s.Interior != "" && s.FractalType == "mandelbrot" {
+		log.Printf("Using %s interior colouring.\n", s.Interior)
+		m = render.GetInteriorFuncHP(
+			a, s.Interior, pixelSize64, s.Colorized,
+		)
+	} else {
+		m = render.GetMandelFuncHP(a, s.FractalType, s.Colorized)
+	}
 
-	log.Printf("Center: (%s, %s).\n", render.BigPrint(cx), render.BigPrint(cy))
 	var img image.Image
-	log.Println("Rendering without anti-aliasing.")
-	img = <-render.RenderMFrameHP(WIDTH, HEIGHT, frameInfo)
-	/*
-		if s.AntiAliasing {
-			log.Println("Rendering with anti-aliasing.")
-			img = <-render.RenderMFrameAA(WIDTH, HEIGHT, frameInfo)
-		} else {
-			log.Println("Rendering without anti-aliasing.")
-			img = <-render.RenderMFrame(WIDTH, HEIGHT, frameInfo)
+	var handle string
+	var err error
+	if buffered(s) || s.Coloring == "histogram" {
+		// The buffer gives histograms the same ranking RenderField does
+		// at regular precision.
+		img, handle, err = renderBuffered(s)
+		if err != nil {
+			return responseStruct{}, err
 		}
-	*/
+	} else if s.AntiAliasing {
+		log.Println("Rendering with anti-aliasing.")
+		img = <-render.RenderMFrameHPAA(WIDTH, HEIGHT, frameInfo, m, aaOptions(s))
+	} else {
+		log.Println("Rendering without anti-aliasing.")
+		img = <-render.RenderMFrameHP(WIDTH, HEIGHT, frameInfo, m)
+	}
 
 	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
 
-	buf := new(bytes.Buffer)
-	jpeg.Encode(buf, img, nil)
-	encodedImage := base64.StdEncoding.EncodeToString(buf.Bytes())
-
-	ret_xmax, _ := xmax.Float64()
-	ret_xmin, _ := xmin.Float64()
-	ret_ymax, _ := ymax.Float64()
-	ret_ymin, _ := ymin.Float64()
-	ret_cx, _ := cx.Float64()
-	ret_cy, _ := cy.Float64()
-	resStruct := responseStruct{
-		Base64: encodedImage,
-		XMax:   ret_xmax,
-		XMin:   ret_xmin,
-		YMax:   ret_ymax,
-		YMin:   ret_ymin,
-		Cx:     ret_cx,
-		Cy:     ret_cy,
+	resStruct := frameResponse(frameInfo.Float64())
+	resStruct.Base64 = encodeImage(img)
+	resStruct.Handle = handle
+	resStruct.Precision = tier
+	resStruct.PrecisionBits = prec
+	resStruct.Exact = &exactBounds{
+		XMax: render.BigPrint(xmax),
+		XMin: render.BigPrint(xmin),
+		YMax: render.BigPrint(ymax),
+		YMin: render.BigPrint(ymin),
+		Cx:   render.BigPrint(cx),
+		Cy:   render.BigPrint(cy),
+		Zoom: render.BigPrint(zoom),
 	}
-	return resStruct
+	return resStruct, nil
 }
 
-func renderNewton(s requestStruct) responseStruct {
-	log.Println("Rendering mandelbrot (regular precision).")
+func renderNewton(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering newton (regular precision).")
 	start := time.Now()
-	cx, cy := s.X, -s.Y
-	boundary := 2.0 / s.Zoom
-	xmin, ymin := (cx - boundary), (cy - boundary)
-	xmax, ymax := (cx + boundary), (cy + boundary)
-
-	frameInfo := render.ConstructFrameInfo(
-		boundary,
-		xmin, ymin,
-		xmax, ymax,
-		cx, cy,
-	)
+	frameInfo := frame(s)
+	boundary, _, _, _, _, cx, cy := frameInfo.Read()
 
+	system := newtonSystem(s.FunctionToUse)
 	var function render.NewtonFunc
-	switch s.FunctionToUse {
-	case "f(z) = z^4 - 1":
-		function = render.NewtonOne(s.Colorized)
-	case "f(z) = z^3 - 1":
-		function = render.NewtonTwo(s.Colorized)
-	case "f(z) = 5cos(3z)":
-		function = render.NewtonThree(s.Colorized)
-	case "f(z) = ln(z)":
-		function = render.NewtonFour(s.Colorized)
-	case "f(z) = z^3 - 1, a = 2":
-		function = render.NewtonFive(s.Colorized)
-	case "f(z) = cosh(z) - 1":
-		function = render.NewtonSix(s.Colorized)
-	default:
-		function = render.NewtonOne(s.Colorized)
+	if s.Coloring == "trap" {
+		log.Println("Using orbit trap.")
+		trap, err := orbitTrap(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+		function = system.Trap(trap, s.Colorized)
+	} else if s.Lighting {
+		log.Println("Using lighting.")
+		function = render.GetLitFunc(
+			system.Value(),
+			system.Newton(s.Colorized),
+			light(s),
+			2*boundary/WIDTH,
+		)
+	} else {
+		function = system.Newton(s.Colorized)
 	}
 
+	log.Printf("Center: (%g, %g).\n", cx, cy)
+	var img image.Image
+	var handle string
+	var err error
+	if buffered(s) {
+		img, handle, err = renderBuffered(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+	} else if s.Coloring == "histogram" {
+		v := system.Value()
+		img = renderEqualized(frameInfo, v, 200, s.Colorized)
+	} else if s.AntiAliasing {
+		log.Println("Rendering with anti-aliasing.")
+		img = <-render.RenderNFrameAA(WIDTH, HEIGHT, frameInfo, function, aaOptions(s))
+	} else {
+		log.Println("Rendering without anti-aliasing.")
+		img = <-render.RenderNFrame(WIDTH, HEIGHT, frameInfo, function)
+	}
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	resStruct.Handle = handle
+	return resStruct, nil
+}
+
+func renderNova(s requestStruct) responseStruct {
+	log.Println("Rendering nova (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	k := complex(s.Cr, s.Ci)
+	system := newtonSystem(s.FunctionToUse)
+	function := system.Nova(s.Colorized, s.Julia, k)
+
 	log.Printf("Center: (%g, %g).\n", cx, cy)
 	var img image.Image
 	if s.AntiAliasing {
 		log.Println("Rendering with anti-aliasing.")
-		img = <-render.RenderNFrameAA(WIDTH, HEIGHT, frameInfo, function)
+		img = <-render.RenderNFrameAA(WIDTH, HEIGHT, frameInfo, function, aaOptions(s))
 	} else {
 		log.Println("Rendering without anti-aliasing.")
 		img = <-render.RenderNFrame(WIDTH, HEIGHT, frameInfo, function)
@@ -219,54 +823,390 @@ func renderNewton(s requestStruct) responseStruct {
 
 	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
 
-	buf := new(bytes.Buffer)
-	jpeg.Encode(buf, img, nil)
-	encodedImage := base64.StdEncoding.EncodeToString(buf.Bytes())
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	return resStruct
+}
+
+func renderMultibrot(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering multibrot (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	boundary, _, _, _, _, cx, cy := frameInfo.Read()
+
+	power := multibrotPower(s)
+	k := complex(s.Cr, s.Ci)
+	if s.Format == "svg" {
+		v := render.GetMultibrotValueFunc(power, s.Julia, k)
+		svg, err := renderContours(s, frameInfo, v)
+		if err != nil {
+			return responseStruct{}, err
+		}
+		log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+		resStruct := frameResponse(frameInfo)
+		resStruct.SVG = svg
+		return resStruct, nil
+	}
+	var m render.MandelFunc
+	if s.Coloring == "trap" {
+		log.Println("Using orbit trap.")
+		trap, err := orbitTrap(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+		m = render.GetMultibrotTrapFunc(power, s.Julia, k, trap, s.Colorized)
+	} else if isAverage(s.Coloring) {
+		log.Printf("Using %s average colouring.\n", s.Coloring)
+		m = render.GetMultibrotAverageFunc(
+			power, s.Julia, k, s.Coloring, stripes(s), s.Colorized,
+		)
+	} else if s.Lighting {
+		log.Println("Using lighting.")
+		m = render.GetLitDistanceFunc(power, s.Julia, k, light(s), s.Colorized)
+	} else if s.Coloring == "distance" {
+		log.Println("Using distance estimation.")
+		m = distanceFunc(s, power, s.Julia, boundary)
+	} else {
+		m = render.GetMultibrotFunc(power, s.Julia, k, s.Colorized)
+	}
 
-	resStruct := responseStruct{
-		Base64: encodedImage,
-		XMax:   xmax,
-		XMin:   xmin,
-		YMax:   ymax,
-		YMin:   ymin,
-		Cx:     cx,
-		Cy:     cy,
+	log.Printf("Center: (%g, %g). Power: %v.\n", cx, cy, power)
+	var img image.Image
+	var handle string
+	var err error
+	if buffered(s) {
+		img, handle, err = renderBuffered(s)
+		if err != nil {
+			return responseStruct{}, err
+		}
+	} else if s.Coloring == "histogram" {
+		v := render.GetMultibrotValueFunc(power, s.Julia, k)
+		img = renderEqualized(frameInfo, v, 100, s.Colorized)
+	} else if s.AntiAliasing {
+		log.Println("Rendering with anti-aliasing.")
+		img = <-render.RenderMFrameAA(WIDTH, HEIGHT, frameInfo, m, aaOptions(s))
+	} else {
+		log.Println("Rendering without anti-aliasing.")
+		img = <-render.RenderMFrame(WIDTH, HEIGHT, frameInfo, m)
 	}
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	resStruct.Handle = handle
+	return resStruct, nil
+}
+
+func renderPhoenix(s requestStruct) responseStruct {
+	log.Println("Rendering phoenix (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	p, q := complex(s.Cr, s.Ci), complex(s.Qr, s.Qi)
+	m := render.GetMemoryFunc(render.Phoenix(q), s.Julia, p, s.Colorized)
+
+	log.Printf("Center: (%g, %g). p: %v, q: %v.\n", cx, cy, p, q)
+	var img image.Image
+	if s.AntiAliasing {
+		log.Println("Rendering with anti-aliasing.")
+		img = <-render.RenderMFrameAA(WIDTH, HEIGHT, frameInfo, m, aaOptions(s))
+	} else {
+		log.Println("Rendering without anti-aliasing.")
+		img = <-render.RenderMFrame(WIDTH, HEIGHT, frameInfo, m)
+	}
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
 	return resStruct
 }
 
+func renderLyapunov(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering lyapunov (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	sequence := s.Sequence
+	if sequence == "" {
+		sequence = "AB"
+	}
+	m, err := render.GetLyapunovFunc(sequence, s.Colorized)
+	if err != nil {
+		return responseStruct{}, err
+	}
+
+	log.Printf("Center: (%g, %g). Sequence: %s.\n", cx, cy, sequence)
+	var img image.Image
+	if s.AntiAliasing {
+		log.Println("Rendering with anti-aliasing.")
+		img = <-render.RenderMFrameAA(WIDTH, HEIGHT, frameInfo, m, aaOptions(s))
+	} else {
+		log.Println("Rendering without anti-aliasing.")
+		img = <-render.RenderMFrame(WIDTH, HEIGHT, frameInfo, m)
+	}
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	return resStruct, nil
+}
+
+func renderBuddhabrot(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering buddhabrot (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	options := render.BuddhabrotOptions{
+		Samples: s.Samples,
+		Anti:    s.Anti,
+		Seed:    s.Seed,
+	}
+	if options.Samples <= 0 {
+		options.Samples = 1000000
+	}
+	iterations := s.Iterations
+	if iterations <= 0 {
+		iterations = 1000
+	}
+	if s.Nebula {
+		options.Limits = [3]int{5000, 500, 50}
+	} else {
+		options.Limits = [3]int{iterations, iterations, iterations}
+	}
+
+	log.Printf("Center: (%g, %g). Samples: %d.\n", cx, cy, options.Samples)
+	c, err := render.RenderBuddhabrot(WIDTH, HEIGHT, frameInfo, options)
+	if err != nil {
+		return responseStruct{}, err
+	}
+	img := <-c
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	return resStruct, nil
+}
+
+func renderIFS(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering ifs (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	maps := s.IFSMaps
+	if len(maps) == 0 {
+		preset, ok := render.IFSPresets[s.IFSPreset]
+		if !ok {
+			return responseStruct{}, fmt.Errorf(
+				"unknown ifs preset %q", s.IFSPreset,
+			)
+		}
+		maps = preset
+	}
+	system, err := render.NewIFS(maps)
+	if err != nil {
+		return responseStruct{}, err
+	}
+
+	options := render.IFSOptions{
+		Points:     s.Samples,
+		Seed:       s.Seed,
+		LogDensity: s.LogDensity,
+		Colorized:  s.Colorized,
+	}
+	if options.Points <= 0 {
+		options.Points = 5000000
+	}
+
+	log.Printf("Center: (%g, %g). Points: %d.\n", cx, cy, options.Points)
+	c, err := render.RenderIFS(WIDTH, HEIGHT, frameInfo, system, options)
+	if err != nil {
+		return responseStruct{}, err
+	}
+	img := <-c
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	return resStruct, nil
+}
+
+func renderFlame(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering flame (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	var flame render.Flame
+	if s.Flame != nil {
+		flame = *s.Flame
+	} else if s.FlameXML != "" {
+		var err error
+		flame, err = render.ParseFlameXML(strings.NewReader(s.FlameXML))
+		if err != nil {
+			return responseStruct{}, err
+		}
+	} else {
+		return responseStruct{}, fmt.Errorf("flame render has no flame")
+	}
+
+	options := render.IFSOptions{
+		Points: s.Samples,
+		Seed:   s.Seed,
+	}
+	if options.Points <= 0 {
+		options.Points = 10000000
+	}
+
+	log.Printf("Center: (%g, %g). Points: %d.\n", cx, cy, options.Points)
+	c, err := render.RenderFlame(WIDTH, HEIGHT, frameInfo, flame, options)
+	if err != nil {
+		return responseStruct{}, err
+	}
+	img := <-c
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	return resStruct, nil
+}
+
+func renderLSystem(s requestStruct) (responseStruct, error) {
+	log.Println("Rendering l-system (regular precision).")
+	start := time.Now()
+	frameInfo := frame(s)
+	_, _, _, _, _, cx, cy := frameInfo.Read()
+
+	var system render.LSystem
+	if s.LSystem != nil {
+		system = *s.LSystem
+	} else {
+		preset, ok := render.LSystemPresets[s.LSystemPreset]
+		if !ok {
+			return responseStruct{}, fmt.Errorf(
+				"unknown l-system preset %q", s.LSystemPreset,
+			)
+		}
+		system = preset
+	}
+	if s.Depth > 0 {
+		system.Depth = s.Depth
+	}
+	segments, err := system.Segments()
+	if err != nil {
+		return responseStruct{}, err
+	}
+
+	log.Printf("Center: (%g, %g). Segments: %d.\n", cx, cy, len(segments))
+	if s.Format == "svg" {
+		svg := render.SegmentsSVG(
+			WIDTH, HEIGHT, frameInfo, segments, s.Colorized,
+		)
+		log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+		resStruct := frameResponse(frameInfo)
+		resStruct.SVG = string(svg)
+		return resStruct, nil
+	}
+	img := <-render.RenderSegments(
+		WIDTH, HEIGHT, frameInfo, segments, s.Colorized,
+	)
+
+	log.Printf("Image rendered. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(frameInfo)
+	resStruct.Base64 = encodeImage(img)
+	return resStruct, nil
+}
+
 func renderFractal(w http.ResponseWriter, r *http.Request) {
 	log.Println("renderFractal received response.")
 
-	decoder := json.NewDecoder(r.Body)
-	var s requestStruct
-	err := decoder.Decode(&s)
-	if err != nil {
-		w.WriteHeader(500)
-		log.Print(err)
+	s, ok := decodeRequest(w, r)
+	if !ok {
 		return
 	}
 
 	log.Println(s)
+	var err error
 	var resStruct responseStruct
-	if s.FractalType == "mandelbrot" {
-		if s.HighPrecision {
-			resStruct = renderMandelbrotHP(s)
+	svgTypes := render.IsVariant(s.FractalType) ||
+		s.FractalType == "multibrot" || s.FractalType == "lsystem"
+	if s.Format == "svg" && !svgTypes {
+		w.WriteHeader(400)
+		log.Printf("svg output is not supported for %s.\n", s.FractalType)
+		return
+	}
+	if render.IsVariant(s.FractalType) {
+		// Contours are traced from the regular precision renderer.
+		tier, prec := precision(s, WIDTH)
+		if tier != render.PrecisionFloat64 && s.Format != "svg" {
+			resStruct, err = renderMandelbrotHP(s, tier, prec)
 		} else {
-			resStruct = renderMandelbrot(s)
+			resStruct, err = renderMandelbrot(s)
 		}
 	} else if s.FractalType == "newton" {
-		resStruct = renderNewton(s)
+		resStruct, err = renderNewton(s)
+	} else if s.FractalType == "nova" {
+		resStruct = renderNova(s)
+	} else if s.FractalType == "multibrot" {
+		resStruct, err = renderMultibrot(s)
+	} else if s.FractalType == "phoenix" {
+		resStruct = renderPhoenix(s)
+	} else if s.FractalType == "lyapunov" {
+		resStruct, err = renderLyapunov(s)
+	} else if s.FractalType == "buddhabrot" {
+		resStruct, err = renderBuddhabrot(s)
+	} else if s.FractalType == "ifs" {
+		resStruct, err = renderIFS(s)
+	} else if s.FractalType == "flame" {
+		resStruct, err = renderFlame(s)
+	} else if s.FractalType == "lsystem" {
+		resStruct, err = renderLSystem(s)
+	}
+	if err != nil {
+		w.WriteHeader(400)
+		log.Print(err)
+		return
+	}
+	writeJSON(w, resStruct)
+}
+
+func recolor(w http.ResponseWriter, r *http.Request) {
+	log.Println("recolor received request.")
+
+	s, ok := decodeRequest(w, r)
+	if !ok {
+		return
 	}
 
-	jsonData, err := json.Marshal(resStruct)
+	if !buffered(s) {
+		w.WriteHeader(400)
+		log.Println("This colouring needs a full render.")
+		return
+	}
+	start := time.Now()
+	b, mode, err := store.get(s.Handle)
 	if err != nil {
-		w.WriteHeader(500)
+		w.WriteHeader(404)
 		log.Print(err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	img := colorBuffer(s, b, mode)
+	log.Printf("Image recoloured. %f s.\n", time.Since(start).Seconds())
+
+	resStruct := frameResponse(b.Frame)
+	resStruct.Base64 = encodeImage(img)
+	resStruct.Handle = s.Handle
+	writeJSON(w, resStruct)
 }
 
 func testStub() {
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Requests over the limits are refused before anything is rendered.
+func TestRenderRejects(t *testing.T) {
+	tests := map[string]string{
+		"mandelbrot levels": `{"fractalType": "mandelbrot", "format": "svg",
+			"levels": 1000000}`,
+		"multibrot levels": `{"fractalType": "multibrot", "format": "svg",
+			"levels": 1000000}`,
+		"l-system depth": `{"fractalType": "lsystem",
+			"lsystem": {"axiom": "A", "rules": {"A": "B", "B": "A"}},
+			"depth": 2000000000}`,
+	}
+	for name, body := range tests {
+		w := httptest.NewRecorder()
+		renderFractal(w, httptest.NewRequest(
+			"POST", "/api/renderFractal", strings.NewReader(body),
+		))
+		if w.Code != 400 {
+			t.Errorf("%s: status %d, want 400", name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/big"
+	"math/rand"
+)
+
+// Supersampling anti-aliasing. Each pixel is sampled at several points
+// around it, the samples are weighted by a reconstruction filter and
+// averaged in linear light, so that a black and white edge blends to the
+// grey the eye expects rather than one that is too dark.
+
+type AAOptions struct {
+	// Samples per pixel, from 1 to 64.
+	Samples int
+	// Where the samples go: "grid", "rotated" (the default), "jittered" or
+	// "poisson". Grids are rounded down to a square number of samples.
+	Pattern string
+	// "box" (the default), "tent", "gaussian" or "lanczos". Filters other
+	// than box reach into neighbouring pixels.
+	Filter string
+	// Seeds the random patterns, so a frame always renders the same.
+	Seed int64
+	// Adaptive renders take one sample per pixel first and supersample
+	// only the pixels that differ from a neighbour by more than Threshold
+	// in any channel, from 0 to 1. Up to Depth levels, each quartering
+	// the pixel, are taken where the samples still differ. Edges are
+	// found by colour alone, so bands that a palette paints alike are not
+	// supersampled where they meet. Grey palettes and those whose colours
+	// wrap around the RGB cube (see rgb16) have such bands.
+	Adaptive  bool
+	Threshold float64
+	Depth     int
+}
+
+// Four samples, box filtered, on edges only.
+var DefaultAA = AAOptions{
+	Samples:   4,
+	Pattern:   "rotated",
+	Filter:    "box",
+	Adaptive:  true,
+	Threshold: 0.05,
+	Depth:     2,
+}
+
+// A reconstruction filter: its weight at x pixels from the centre, and
+// how far it reaches.
+type aaFilter struct {
+	weight func(x float64) float64
+	radius float64
+}
+
+var aaFilters = map[string]aaFilter{
+	"box": {func(x float64) float64 { return 1 }, 0.5},
+	"tent": {func(x float64) float64 {
+		return math.Max(1-math.Abs(x), 0)
+	}, 1},
+	"gaussian": {func(x float64) float64 {
+		const sigma = 0.5
+		return math.Exp(-x * x / (2 * sigma * sigma))
+	}, 1.5},
+	"lanczos": {func(x float64) float64 {
+		const a = 2
+		return sinc(x) * sinc(x/a)
+	}, 2},
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// sRGB to linear light for each 16-bit channel value from RGBA.
+var linearLight = func() []float64 {
+	table := make([]float64, 1<<16)
+	for i := range table {
+		table[i], _, _ = colorful.Color{R: float64(i) / 0xffff}.LinearRgb()
+	}
+	return table
+}()
+
+func (o AAOptions) normalize() AAOptions {
+	if o.Samples < 1 {
+		o.Samples = 1
+	}
+	if o.Samples > 64 {
+		o.Samples = 64
+	}
+	if _, ok := aaFilters[o.Filter]; !ok {
+		o.Filter = "box"
+	}
+	switch o.Pattern {
+	case "grid", "rotated", "jittered", "poisson":
+	default:
+		o.Pattern = "rotated"
+	}
+	if o.Depth < 1 {
+		o.Depth = 1
+	}
+	if o.Depth > 4 {
+		o.Depth = 4
+	}
+	return o
+}
+
+// Returns the sample points of one pixel in the unit square. Fixed
+// patterns are the same for every pixel; random ones draw from r.
+func (o AAOptions) points(r *rand.Rand) [][2]float64 {
+	n := o.Samples
+	k := int(math.Sqrt(float64(n)))
+	var pts [][2]float64
+	switch o.Pattern {
+	case "grid":
+		for i := 0; i < k*k; i++ {
+			pts = append(pts, [2]float64{
+				(float64(i%k) + 0.5) / float64(k),
+				(float64(i/k) + 0.5) / float64(k),
+			})
+		}
+	case "rotated":
+		// A grid turned by atan(1/2) and wrapped back into the square,
+		// so that no two samples share a row or a column.
+		angle := math.Atan(0.5)
+		sin, cos := math.Sin(angle), math.Cos(angle)
+		for i := 0; i < n; i++ {
+			gx := (float64(i%k) + 0.5) / float64(k)
+			gy := (float64(i/k) + 0.5) / float64(k)
+			if i >= k*k {
+				gx, gy = r.Float64(), r.Float64()
+			}
+			x := 0.5 + (gx-0.5)*cos - (gy-0.5)*sin
+			y := 0.5 + (gx-0.5)*sin + (gy-0.5)*cos
+			pts = append(pts, [2]float64{
+				x - math.Floor(x), y - math.Floor(y),
+			})
+		}
+	case "jittered":
+		// One random point in each cell of a grid, and the rest
+		// anywhere.
+		for i := 0; i < n; i++ {
+			if i < k*k {
+				pts = append(pts, [2]float64{
+					(float64(i%k) + r.Float64()) / float64(k),
+					(float64(i/k) + r.Float64()) / float64(k),
+				})
+			} else {
+				pts = append(pts, [2]float64{r.Float64(), r.Float64()})
+			}
+		}
+	case "poisson":
+		// Dart throwing: random points no closer than minDist, settling
+		// for any point once too many darts miss.
+		minDist := 0.75 / math.Sqrt(float64(n))
+		for tries := 0; len(pts) < n; tries++ {
+			p := [2]float64{r.Float64(), r.Float64()}
+			ok := true
+			for _, q := range pts {
+				if math.Hypot(p[0]-q[0], p[1]-q[1]) < minDist {
+					ok = false
+					break
+				}
+			}
+			if ok || tries > 30*n {
+				pts = append(pts, p)
+			}
+		}
+	}
+	return pts
+}
+
+// Evaluates the frame at a point given in pixels, fractions allowed.
+// Float64 and big.Float frames both render through one.
+type pixelFunc func(px, py float64) color.Color
+
+// A colour in linear light.
+type linearColor [3]float64
+
+// The palettes can build colours outside the RGB cube, whose channels
+// wrap around when an image stores them. Masking wraps them the same way.
+func rgb16(c color.Color) (r, g, b uint32) {
+	r, g, b, _ = c.RGBA()
+	return r & 0xffff, g & 0xffff, b & 0xffff
+}
+
+func toLinear(c color.Color) linearColor {
+	r, g, b := rgb16(c)
+	return linearColor{linearLight[r], linearLight[g], linearLight[b]}
+}
+
+// The largest difference between any channel of two colours, compared as
+// stored rather than in linear light so that dark edges count as much as
+// light ones.
+func colorDistance(a, b color.Color) float64 {
+	ar, ag, ab := rgb16(a)
+	br, bg, bb := rgb16(b)
+	d := 0.0
+	for _, v := range [][2]uint32{{ar, br}, {ag, bg}, {ab, bb}} {
+		d = math.Max(d, math.Abs(float64(v[0])-float64(v[1]))/0xffff)
+	}
+	return d
+}
+
+// Samples the pixel at (px, py), size pixels across, and filters the
+// samples down to one colour. While the samples differ by more than the
+// threshold and depth allows, each quarter of the pixel is sampled again
+// on its own.
+func (o AAOptions) refine(
+	px, py, size float64,
+	depth int,
+	r *rand.Rand,
+	at pixelFunc,
+) linearColor {
+	filter := aaFilters[o.Filter]
+	// box is the plain sum, for when the lobes of the filter cancel out.
+	var sum, box linearColor
+	var total, spread float64
+	var first color.Color
+	samples := 0
+	for _, p := range o.points(r) {
+		dx := (p[0] - 0.5) * 2 * filter.radius
+		dy := (p[1] - 0.5) * 2 * filter.radius
+		w := filter.weight(dx) * filter.weight(dy)
+		c := at(px+dx*size, py+dy*size)
+		if first == nil {
+			first = c
+		} else {
+			spread = math.Max(spread, colorDistance(first, c))
+		}
+		l := toLinear(c)
+		for i := range sum {
+			sum[i] += w * l[i]
+			box[i] += l[i]
+		}
+		total += w
+		samples++
+	}
+
+	if depth > 1 && spread > o.Threshold {
+		var quarters linearColor
+		for _, q := range [][2]float64{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+			l := o.refine(
+				px+q[0]*size/4, py+q[1]*size/4, size/2, depth-1, r, at,
+			)
+			for i := range quarters {
+				quarters[i] += l[i] / 4
+			}
+		}
+		return quarters
+	}
+	if total <= 0 {
+		sum, total = box, float64(samples)
+	}
+	if total == 0 {
+		return linearColor{}
+	}
+	for i := range sum {
+		sum[i] /= total
+	}
+	return sum
+}
+
+func (l linearColor) color() color.Color {
+	return colorful.LinearRgb(l[0], l[1], l[2]).Clamped()
+}
+
+func renderBoundsAA(
+	width, height int,
+	at pixelFunc,
+	o AAOptions,
+	seed int64,
+) <-chan image.Image {
+	c := make(chan image.Image)
+	go func() {
+		r := rand.New(rand.NewSource(seed))
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		if !o.Adaptive {
+			for py := 0; py < height; py++ {
+				for px := 0; px < width; px++ {
+					l := o.refine(float64(px), float64(py), 1, 1, r, at)
+					img.Set(px, py, l.color())
+				}
+			}
+			c <- img
+			return
+		}
+
+		// A first pass at one sample per pixel, with a border of one
+		// pixel so that edges on the border of the bounds are found.
+		stride := width + 2
+		pass := make([]color.Color, stride*(height+2))
+		for py := -1; py <= height; py++ {
+			for px := -1; px <= width; px++ {
+				pass[(py+1)*stride+px+1] = at(float64(px), float64(py))
+			}
+		}
+		refined := 0
+		for py := 0; py < height; py++ {
+			for px := 0; px < width; px++ {
+				i := (py+1)*stride + px + 1
+				edge := false
+				for _, j := range []int{i - 1, i + 1, i - stride, i + stride} {
+					if colorDistance(pass[i], pass[j]) > o.Threshold {
+						edge = true
+						break
+					}
+				}
+				if !edge {
+					img.Set(px, py, pass[i])
+					continue
+				}
+				refined++
+				l := o.refine(float64(px), float64(py), 1, o.Depth, r, at)
+				img.Set(px, py, l.color())
+			}
+		}
+		log.Printf("Supersampled %d of %d pixels.\n", refined, width*height)
+		c <- img
+	}()
+	return c
+}
+
+// Renders the frame a quarter at a time, as RenderMFrame does. MandelFunc
+// and NewtonFunc both fit fn.
+func renderFrameAA(
+	width, height int,
+	f FrameInfo,
+	fn func(complex128) color.Color,
+	o AAOptions,
+) <-chan image.Image {
+	o = o.normalize()
+	boundary, xmin, ymin, _, _, cx, cy := f.Read()
+	quarter := func(x0, y0 float64, i int64) <-chan image.Image {
+		log.Printf("rendering bounds (%f, %f), (%f, %f)\n",
+			x0, y0, x0+boundary, y0+boundary)
+		stepX := boundary / float64(width/2)
+		stepY := boundary / float64(height/2)
+		at := func(px, py float64) color.Color {
+			return fn(complex(x0+px*stepX, y0+py*stepY))
+		}
+		return renderBoundsAA(width/2, height/2, at, o, o.Seed+i)
+	}
+	return combine(width, height,
+		quarter(xmin, ymin, 0),
+		quarter(cx, ymin, 1),
+		quarter(xmin, cy, 2),
+		quarter(cx, cy, 3),
+	)
+}
+
+// Supersamples a high-precision frame. Sample points are worked out at
+// the precision of the frame, so they stay distinct however deep it is.
+func RenderMFrameHPAA(
+	width, height int,
+	f FrameInfoHP,
+	m MandelFuncHP,
+	o AAOptions,
+) <-chan image.Image {
+	o = o.normalize()
+	boundary, xmin, ymin, _, _, cx, cy := f.Read()
+	quarter := func(x0, y0 *big.Float, i int64) <-chan image.Image {
+		log.Printf("rendering bounds (%s, %s), (%s, %s)\n",
+			BigPrint(x0), BigPrint(y0),
+			BigPrint(new(big.Float).Add(x0, boundary)),
+			BigPrint(new(big.Float).Add(y0, boundary)))
+		stepX := new(big.Float).Quo(boundary, big.NewFloat(float64(width/2)))
+		stepY := new(big.Float).Quo(boundary, big.NewFloat(float64(height/2)))
+		at := func(px, py float64) color.Color {
+			x := new(big.Float).Mul(stepX, big.NewFloat(px))
+			y := new(big.Float).Mul(stepY, big.NewFloat(py))
+			return m(x.Add(x, x0), y.Add(y, y0))
+		}
+		return renderBoundsAA(width/2, height/2, at, o, o.Seed+i)
+	}
+	return combine(width, height,
+		quarter(xmin, ymin, 0),
+		quarter(cx, ymin, 1),
+		quarter(xmin, cy, 2),
+		quarter(cx, cy, 3),
+	)
+}
@@ -0,0 +1,174 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Averaging colour algorithms. A statistic of each point of the orbit is
+// averaged up to the escape, and the averages with and without the last
+// point are blended by the fractional escape count so that the result is
+// continuous across iteration bands.
+
+// A statistic of z, given the two points before it and p, the power of
+// the previous point that c was added to.
+type orbitStatistic func(z, prev, prev2, p, c complex128) float64
+
+// Returns the statistic named kind and how many points at the start of
+// the orbit it skips. Unknown kinds fall back to "stripe".
+func statistic(kind string, density float64) (orbitStatistic, int) {
+	switch kind {
+	case "tia":
+		// Where |z| falls between the bounds the triangle inequality
+		// puts on |p + c|.
+		return func(z, prev, prev2, p, c complex128) float64 {
+			lo := math.Abs(cmplx.Abs(p) - cmplx.Abs(c))
+			hi := cmplx.Abs(p) + cmplx.Abs(c)
+			return (cmplx.Abs(z) - lo) / (hi - lo)
+		}, 1
+	case "curvature":
+		// How sharply the orbit turns at prev.
+		return func(z, prev, prev2, p, c complex128) float64 {
+			return math.Abs(cmplx.Phase((z-prev)/(prev-prev2))) / math.Pi
+		}, 2
+	default:
+		return func(z, prev, prev2, p, c complex128) float64 {
+			return 0.5 + 0.5*math.Sin(density*cmplx.Phase(z))
+		}, 1
+	}
+}
+
+func averageColor(v float64, colorized bool) color.Color {
+	v = math.Min(math.Max(v, 0), 1)
+	if colorized {
+		return colorful.Hsv(math.Mod(200+300*v, 360), 0.6, 0.2+0.8*v)
+	}
+	return color.Gray{uint8(255 * v)}
+}
+
+// Follows the orbit from z until it escapes or runs out of iterations.
+// next returns the power of z that c is added to and the point after z.
+func averageOrbit(
+	z, c complex128,
+	next func(z complex128) (complex128, complex128),
+	d complex128,
+	stat orbitStatistic, skip int,
+	colorized bool,
+) color.Color {
+	const iterations = 100
+
+	// The blend only works well far beyond the usual bailout.
+	radius := math.Max(escapeRadius(d), 1000)
+	var sum, last float64
+	var count int
+	var p complex128
+	prev, prev2 := z, z
+	for n := 0; n < iterations; n++ {
+		prev, prev2 = z, prev
+		p, z = next(z)
+		last = math.NaN()
+		if n >= skip {
+			last = stat(z, prev, prev2, p, c)
+			if !math.IsNaN(last) && !math.IsInf(last, 0) {
+				sum += last
+				count++
+			}
+		}
+		if cmplx.Abs(z) <= radius {
+			continue
+		}
+		if count == 0 {
+			return averageColor(0, colorized)
+		}
+		average := sum / float64(count)
+		if count == 1 || math.IsNaN(last) || math.IsInf(last, 0) {
+			return averageColor(average, colorized)
+		}
+		before := (sum - last) / float64(count-1)
+		w := smoothIteration(n, z, radius, d) - float64(n)
+		w = math.Min(math.Max(w, 0), 1)
+		return averageColor(w*average+(1-w)*before, colorized)
+	}
+	return color.Black
+}
+
+// Steps for averageOrbit from step, which must be the power of z plus c.
+func powerSteps(
+	step func(z, c complex128) complex128, c complex128,
+) func(z complex128) (complex128, complex128) {
+	return func(z complex128) (complex128, complex128) {
+		p := step(z, 0)
+		return p, p + c
+	}
+}
+
+// Colours an escape-time variant by the average of kind, one of "stripe",
+// "tia" or "curvature". density sets the number of stripes.
+func GetAverageFunc(
+	fractalType, kind string, density float64, colorized bool,
+) MandelFunc {
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	stat, skip := statistic(kind, density)
+	return func(c complex128) color.Color {
+		next := powerSteps(v.step, c)
+		return averageOrbit(0, c, next, 2, stat, skip, colorized)
+	}
+}
+
+// Like GetAverageFunc for high precision renders. The orbit is followed
+// at high precision and averaged in float64.
+func GetAverageFuncHP(
+	a *Arithmetic,
+	fractalType, kind string, density float64,
+	colorized bool,
+) MandelFuncHP {
+	const iterations = 100
+
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	stat, skip := statistic(kind, density)
+	return func(cR, cI *big.Float) color.Color {
+		// Orbits that pass 2 always reach the larger bailout long before
+		// they run out of extra steps.
+		orbit, _ := a.orbit(v, cR, cI, iterations, iterations, 1000)
+		c := complexHP(cR, cI)
+		i := 0
+		next := func(complex128) (complex128, complex128) {
+			z := orbit[i]
+			i++
+			return z - c, z
+		}
+		return averageOrbit(0, c, next, 2, stat, skip, colorized)
+	}
+}
+
+// Like GetAverageFunc, for z = z^d + c.
+func GetMultibrotAverageFunc(
+	d complex128, julia bool, k complex128,
+	kind string, density float64,
+	colorized bool,
+) MandelFunc {
+	pow := powFunc(d)
+	step := func(z, c complex128) complex128 {
+		return pow(z) + c
+	}
+	stat, skip := statistic(kind, density)
+	if julia {
+		return func(z complex128) color.Color {
+			next := powerSteps(step, k)
+			return averageOrbit(z, k, next, d, stat, skip, colorized)
+		}
+	}
+	return func(c complex128) color.Color {
+		next := powerSteps(step, c)
+		return averageOrbit(0, c, next, d, stat, skip, colorized)
+	}
+}
@@ -0,0 +1,344 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Buddhabrot renders colour the frame by how often escaping orbits pass
+// through each pixel rather than by each pixel's own orbit, so they sample
+// c values at random instead of going pixel by pixel.
+
+type BuddhabrotOptions struct {
+	// Number of random c values to sample.
+	Samples int
+	// Iteration limits for the red, green and blue channels. Equal limits
+	// give a monochrome Buddhabrot; distinct ones give a Nebulabrot.
+	Limits [3]int
+	// Plot the orbits that do not escape instead (anti-Buddhabrot).
+	Anti bool
+	// Renders with the same seed and options are identical.
+	Seed int64
+}
+
+// Bounds on one render. Each worker holds an orbit as long as the largest
+// limit, and the work grows with samples times that limit.
+const (
+	MaxBuddhabrotSamples    = 20000000
+	MaxBuddhabrotIterations = 100000
+	maxBuddhabrotSteps      = 20000000000
+)
+
+func (o BuddhabrotOptions) maxLimit() int {
+	limit := o.Limits[0]
+	for _, l := range o.Limits {
+		if l > limit {
+			limit = l
+		}
+	}
+	return limit
+}
+
+func (o BuddhabrotOptions) check() error {
+	limit := o.maxLimit()
+	switch {
+	case o.Samples > MaxBuddhabrotSamples:
+		return fmt.Errorf(
+			"buddhabrot samples %d is over the limit of %d",
+			o.Samples, MaxBuddhabrotSamples,
+		)
+	case limit > MaxBuddhabrotIterations:
+		return fmt.Errorf(
+			"buddhabrot iterations %d is over the limit of %d",
+			limit, MaxBuddhabrotIterations,
+		)
+	case float64(o.Samples)*float64(limit) > maxBuddhabrotSteps:
+		return fmt.Errorf(
+			"buddhabrot samples times iterations is over the limit of %g",
+			float64(maxBuddhabrotSteps),
+		)
+	}
+	return nil
+}
+
+// Accumulates weighted hits over the bounds of a frame.
+type histogram struct {
+	width, height int
+	xmin, ymin    float64
+	xscale        float64
+	yscale        float64
+	bins          []float64
+}
+
+func newHistogram(width, height int, xmin, ymin, xmax, ymax float64) *histogram {
+	return &histogram{
+		width:  width,
+		height: height,
+		xmin:   xmin,
+		ymin:   ymin,
+		xscale: float64(width) / (xmax - xmin),
+		yscale: float64(height) / (ymax - ymin),
+		bins:   make([]float64, width*height),
+	}
+}
+
+// Returns the bin containing (x, y), if it lies inside the frame.
+func (h *histogram) index(x, y float64) (int, bool) {
+	px := int(math.Floor((x - h.xmin) * h.xscale))
+	py := int(math.Floor((y - h.ymin) * h.yscale))
+	if px < 0 || py < 0 || px >= h.width || py >= h.height {
+		return 0, false
+	}
+	return py*h.width + px, true
+}
+
+func (h *histogram) plot(x, y, weight float64) {
+	if i, ok := h.index(x, y); ok {
+		h.bins[i] += weight
+	}
+}
+
+func (h *histogram) add(o *histogram) {
+	for i, v := range o.bins {
+		h.bins[i] += v
+	}
+}
+
+// Returns the value below which the given fraction of non-empty bins
+// fall, so that a few very hot pixels do not wash out the rest.
+func (h *histogram) quantile(q float64) float64 {
+	values := make([]float64, 0, len(h.bins))
+	for _, v := range h.bins {
+		if v > 0 {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return 1
+	}
+	sort.Float64s(values)
+	return values[int(q*float64(len(values)-1))]
+}
+
+// Inside the main cardioid or the period-2 bulb; these never escape.
+func inMainBulbs(c complex128) bool {
+	x, y := real(c), imag(c)
+	q := (x-0.25)*(x-0.25) + y*y
+	if q*(q+(x-0.25)) <= 0.25*y*y {
+		return true
+	}
+	return (x+1)*(x+1)+y*y <= 0.0625
+}
+
+// The sampler favours cells of a coarse grid over [-2, 2]^2 that lie on
+// the boundary of the set, where the long, interesting orbits start. Each
+// sample carries the weight that undoes this bias.
+type buddhaSampler struct {
+	grid     int
+	cellSize float64
+	boundary []int
+	// Probability of drawing from the boundary cells instead of the
+	// whole square.
+	bias float64
+}
+
+func newBuddhaSampler(limit int) buddhaSampler {
+	const grid = 128
+	const span = 4.0
+
+	cellSize := span / grid
+	escapes := make([]bool, grid*grid)
+	for gy := 0; gy < grid; gy++ {
+		for gx := 0; gx < grid; gx++ {
+			c := complex(
+				-2+(float64(gx)+0.5)*cellSize,
+				-2+(float64(gy)+0.5)*cellSize,
+			)
+			var z complex128
+			escaped := false
+			for n := 0; n < limit; n++ {
+				z = z*z + c
+				if real(z)*real(z)+imag(z)*imag(z) > 4 {
+					escaped = true
+					break
+				}
+			}
+			escapes[gy*grid+gx] = escaped
+		}
+	}
+
+	s := buddhaSampler{grid: grid, cellSize: cellSize, bias: 0.75}
+	for gy := 0; gy < grid; gy++ {
+		for gx := 0; gx < grid; gx++ {
+			i := gy*grid + gx
+			mixed := false
+			for dy := -1; dy <= 1 && !mixed; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := gx+dx, gy+dy
+					if nx < 0 || ny < 0 || nx >= grid || ny >= grid {
+						continue
+					}
+					if escapes[ny*grid+nx] != escapes[i] {
+						mixed = true
+						break
+					}
+				}
+			}
+			if mixed {
+				s.boundary = append(s.boundary, i)
+			}
+		}
+	}
+	if len(s.boundary) == 0 {
+		s.bias = 0
+	}
+	return s
+}
+
+// Returns a random c and its importance weight.
+func (s buddhaSampler) sample(r *rand.Rand) (complex128, float64) {
+	const span = 4.0
+
+	var c complex128
+	if r.Float64() < s.bias {
+		i := s.boundary[r.Intn(len(s.boundary))]
+		gx, gy := i%s.grid, i/s.grid
+		c = complex(
+			-2+(float64(gx)+r.Float64())*s.cellSize,
+			-2+(float64(gy)+r.Float64())*s.cellSize,
+		)
+	} else {
+		c = complex(-2+r.Float64()*span, -2+r.Float64()*span)
+	}
+
+	// Density of the mixture relative to uniform sampling of the square.
+	density := 1 - s.bias
+	gx := int((real(c) + 2) / s.cellSize)
+	gy := int((imag(c) + 2) / s.cellSize)
+	inGrid := gx < s.grid && gy < s.grid
+	if s.bias > 0 && inGrid && s.isBoundary(gy*s.grid+gx) {
+		boundaryArea := float64(len(s.boundary)) * s.cellSize * s.cellSize
+		density += s.bias * span * span / boundaryArea
+	}
+	return c, 1 / density
+}
+
+func (s buddhaSampler) isBoundary(i int) bool {
+	j := sort.SearchInts(s.boundary, i)
+	return j < len(s.boundary) && s.boundary[j] == i
+}
+
+func buddhaWorker(
+	width, height int,
+	xmin, ymin, xmax, ymax float64,
+	o BuddhabrotOptions,
+	s buddhaSampler,
+	samples int,
+	seed int64,
+) [3]*histogram {
+	var hists [3]*histogram
+	for k := range hists {
+		hists[k] = newHistogram(width, height, xmin, ymin, xmax, ymax)
+	}
+	limit := o.maxLimit()
+
+	r := rand.New(rand.NewSource(seed))
+	orbit := make([]complex128, limit)
+	for i := 0; i < samples; i++ {
+		c, weight := s.sample(r)
+		if !o.Anti && inMainBulbs(c) {
+			continue
+		}
+
+		var z complex128
+		n := 0
+		for ; n < limit; n++ {
+			z = z*z + c
+			orbit[n] = z
+			if real(z)*real(z)+imag(z)*imag(z) > 4 {
+				break
+			}
+		}
+
+		for k, h := range hists {
+			escaped := n < o.Limits[k]
+			if escaped == o.Anti {
+				continue
+			}
+			length := n
+			if length > o.Limits[k] {
+				length = o.Limits[k]
+			}
+			for _, p := range orbit[:length] {
+				h.plot(real(p), imag(p), weight)
+			}
+		}
+	}
+	return hists
+}
+
+func RenderBuddhabrot(
+	width, height int, f FrameInfo, o BuddhabrotOptions,
+) (<-chan image.Image, error) {
+	const workers = 4
+
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering buddhabrot (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
+	c := make(chan image.Image)
+	go func() {
+		sampler := newBuddhaSampler(o.maxLimit())
+
+		var wg sync.WaitGroup
+		results := make([][3]*histogram, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				samples := o.Samples / workers
+				if w < o.Samples%workers {
+					samples++
+				}
+				results[w] = buddhaWorker(
+					width, height,
+					xmin, ymin, xmax, ymax,
+					o, sampler, samples, o.Seed+int64(w),
+				)
+			}(w)
+		}
+		wg.Wait()
+
+		hists := results[0]
+		for _, r := range results[1:] {
+			for k := range hists {
+				hists[k].add(r[k])
+			}
+		}
+
+		var scales [3]float64
+		for k, h := range hists {
+			scales[k] = h.quantile(0.999)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for py := 0; py < height; py++ {
+			for px := 0; px < width; px++ {
+				var rgb [3]uint8
+				for k, h := range hists {
+					v := math.Sqrt(h.bins[py*width+px] / scales[k])
+					rgb[k] = uint8(255 * math.Min(v, 1))
+				}
+				img.Set(px, py, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+			}
+		}
+		c <- img
+	}()
+	return c, nil
+}
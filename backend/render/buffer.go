@@ -0,0 +1,529 @@
+package render
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"sort"
+	"sync"
+)
+
+// Rendering in two stages. The compute stage records what the orbit of
+// each pixel did in a Buffer, and the colouring stage turns a Buffer into
+// an image. Holding on to the Buffer lets a frame be recoloured without
+// iterating again.
+
+// What the compute stage records about one point.
+type Sample struct {
+	// The iteration at which the orbit escaped or converged, and the
+	// smooth count. The iteration limit for orbits that do neither.
+	Count      int
+	Iterations float64
+	// Whether the orbit escaped, or converged in Newton renders.
+	Escaped bool
+	// The last point of the orbit.
+	Z complex128
+	// Exterior distance estimate, or 0 where there is none.
+	Distance float64
+	// Newton SampleFuncs set this to 0 for orbits that converge and
+	// RenderBuffer numbers the roots in the order it meets them, row by
+	// row. -1 otherwise.
+	Root int
+	// Period of the cycle an interior orbit settles into, or 0.
+	Period int
+}
+
+type SampleFunc func(complex128) Sample
+type SampleFuncHP func(x, y *big.Float) Sample
+
+// Samples of a frame, one slice per quantity, stored row by row. Values
+// are kept in single precision to keep cached buffers small.
+type Buffer struct {
+	Width, Height int
+	Frame         FrameInfo
+
+	Count      []int16
+	Iterations []float32
+	Escaped    []bool
+	Z          []complex64
+	Distance   []float32
+	Root       []int16
+	Period     []int16
+}
+
+func newBuffer(width, height int, f FrameInfo) *Buffer {
+	n := width * height
+	return &Buffer{
+		Width:      width,
+		Height:     height,
+		Frame:      f,
+		Count:      make([]int16, n),
+		Iterations: make([]float32, n),
+		Escaped:    make([]bool, n),
+		Z:          make([]complex64, n),
+		Distance:   make([]float32, n),
+		Root:       make([]int16, n),
+		Period:     make([]int16, n),
+	}
+}
+
+func (b *Buffer) set(i int, s Sample) {
+	b.Count[i] = int16(s.Count)
+	b.Iterations[i] = float32(s.Iterations)
+	b.Escaped[i] = s.Escaped
+	b.Z[i] = complex64(s.Z)
+	b.Distance[i] = float32(s.Distance)
+	b.Root[i] = int16(s.Root)
+	b.Period[i] = int16(s.Period)
+}
+
+func (b *Buffer) At(px, py int) Sample {
+	i := py*b.Width + px
+	return Sample{
+		Count:      int(b.Count[i]),
+		Iterations: float64(b.Iterations[i]),
+		Escaped:    b.Escaped[i],
+		Z:          complex128(b.Z[i]),
+		Distance:   float64(b.Distance[i]),
+		Root:       int(b.Root[i]),
+		Period:     int(b.Period[i]),
+	}
+}
+
+// Width of a pixel in the complex plane. It comes from the boundary,
+// which keeps its precision in frames too deep for xmax - xmin to.
+func (b *Buffer) PixelSize() float64 {
+	boundary, _, _, _, _, _, _ := b.Frame.Read()
+	return 2 * boundary / float64(b.Width)
+}
+
+// Whether the compute stage estimated distances, which only some fractal
+// types do. Escaped samples always have one when it did.
+func (b *Buffer) hasDistance() bool {
+	for i, d := range b.Distance {
+		if b.Escaped[i] && d != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Numbers the roots that converged samples ended up at.
+func (b *Buffer) numberRoots() {
+	const tolerance = 1e-3
+	const maxRoots = math.MaxInt16
+
+	var roots []complex128
+	for i, root := range b.Root {
+		if root < 0 {
+			continue
+		}
+		z := complex128(b.Z[i])
+		j := 0
+		for j < len(roots) &&
+			cmplx.Abs(z-roots[j]) >= tolerance*math.Max(1, cmplx.Abs(z)) {
+			j++
+		}
+		if j == len(roots) && len(roots) < maxRoots {
+			roots = append(roots, z)
+		}
+		b.Root[i] = int16(j)
+	}
+}
+
+// Samples s at the same points as RenderMFrame, one band of rows per
+// goroutine.
+func RenderBuffer(width, height int, f FrameInfo, s SampleFunc) <-chan *Buffer {
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering buffer (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
+	return renderBuffer(width, height, f, func(px, py int) Sample {
+		x := float64(px)/float64(width)*(xmax-xmin) + xmin
+		y := float64(py)/float64(height)*(ymax-ymin) + ymin
+		return s(complex(x, y))
+	})
+}
+
+// Like RenderBuffer, placing the samples at high precision. The Buffer
+// keeps the frame rounded to float64.
+func RenderBufferHP(
+	width, height int, f FrameInfoHP, s SampleFuncHP,
+) <-chan *Buffer {
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering buffer (%s, %s), (%s, %s)\n",
+		BigPrint(xmin), BigPrint(ymin), BigPrint(xmax), BigPrint(ymax))
+	stepX := new(big.Float).Sub(xmax, xmin)
+	stepX.Quo(stepX, big.NewFloat(float64(width)))
+	stepY := new(big.Float).Sub(ymax, ymin)
+	stepY.Quo(stepY, big.NewFloat(float64(height)))
+	return renderBuffer(width, height, f.Float64(), func(px, py int) Sample {
+		x := new(big.Float).Mul(stepX, big.NewFloat(float64(px)))
+		y := new(big.Float).Mul(stepY, big.NewFloat(float64(py)))
+		return s(x.Add(x, xmin), y.Add(y, ymin))
+	})
+}
+
+func renderBuffer(
+	width, height int, f FrameInfo, at func(px, py int) Sample,
+) <-chan *Buffer {
+	const bands = 4
+
+	c := make(chan *Buffer)
+	go func() {
+		buffer := newBuffer(width, height, f)
+		var wg sync.WaitGroup
+		for b := 0; b < bands; b++ {
+			wg.Add(1)
+			go func(b int) {
+				defer wg.Done()
+				for py := b * height / bands; py < (b+1)*height/bands; py++ {
+					for px := 0; px < width; px++ {
+						buffer.set(py*width+px, at(px, py))
+					}
+				}
+			}(b)
+		}
+		wg.Wait()
+		buffer.numberRoots()
+		c <- buffer
+	}()
+	return c
+}
+
+const bufferMagic = "FHBUF001"
+
+// The quantities of b in the order they are written.
+func (b *Buffer) channels() []interface{} {
+	return []interface{}{
+		b.Count, b.Iterations, b.Escaped, b.Z, b.Distance, b.Root, b.Period,
+	}
+}
+
+// Writes b in a little-endian binary form that ReadBuffer reads back: a
+// header with the size and frame, then each quantity in turn.
+func WriteBuffer(w io.Writer, b *Buffer) error {
+	boundary, xmin, ymin, xmax, ymax, cx, cy := b.Frame.Read()
+	header := []interface{}{
+		[]byte(bufferMagic),
+		uint32(b.Width), uint32(b.Height),
+		[]float64{boundary, xmin, ymin, xmax, ymax, cx, cy},
+	}
+	for _, v := range append(header, b.channels()...) {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadBuffer(r io.Reader) (*Buffer, error) {
+	const maxPixels = 1 << 26
+
+	magic := make([]byte, len(bufferMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != bufferMagic {
+		return nil, fmt.Errorf("not a buffer")
+	}
+	var size [2]uint32
+	var frame [7]float64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &frame); err != nil {
+		return nil, err
+	}
+	width, height := int(size[0]), int(size[1])
+	if width*height > maxPixels {
+		return nil, fmt.Errorf("buffer of %dx%d is too large", width, height)
+	}
+	f := ConstructFrameInfo(
+		frame[0], frame[1], frame[2], frame[3], frame[4], frame[5], frame[6],
+	)
+	b := newBuffer(width, height, f)
+	for _, v := range b.channels() {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Follows z under step until it passes radius. Orbits that do not escape
+// are followed on to find their period.
+func escapeSample(
+	z, c complex128,
+	step func(z, c complex128) complex128,
+	radius float64, d complex128,
+) Sample {
+	const iterations = 100
+
+	for n := 0; n < iterations; n++ {
+		z = step(z, c)
+		if cmplx.Abs(z) > radius {
+			return Sample{
+				Count:      n,
+				Iterations: smoothIteration(n, z, radius, d),
+				Escaped:    true,
+				Z:          z,
+				Root:       -1,
+			}
+		}
+	}
+	p, _ := cyclePeriod(z, c, step)
+	return Sample{
+		Count:      iterations,
+		Iterations: iterations,
+		Z:          z,
+		Root:       -1,
+		Period:     p,
+	}
+}
+
+// Samples an escape-time variant. Unknown fractal types fall back to the
+// standard Mandelbrot set, which also gets a distance estimate.
+func GetSampleFunc(fractalType string) SampleFunc {
+	v, ok := variants[fractalType]
+	if !ok || fractalType == "mandelbrot" {
+		return GetMultibrotSampleFunc(2, false, 0)
+	}
+	return func(c complex128) Sample {
+		return escapeSample(0, c, v.step, 2, 2)
+	}
+}
+
+// Like GetSampleFunc, following orbits at high precision. Everything but
+// the orbit itself is worked out in float64.
+func GetSampleFuncHP(a *Arithmetic, fractalType string) SampleFuncHP {
+	const iterations = 100
+	// As in GetMultibrotSampleFunc.
+	const extra = 20
+	const deRadius = 1000
+
+	v, ok := variants[fractalType]
+	mandelbrot := !ok || fractalType == "mandelbrot"
+	if mandelbrot {
+		v = variants["mandelbrot"]
+	}
+	return func(x, y *big.Float) Sample {
+		var orbit []complex128
+		var n int
+		if mandelbrot {
+			orbit, n = a.orbit(v, x, y, iterations, extra, deRadius)
+		} else {
+			orbit, n = a.orbit(v, x, y, iterations, 0, 2)
+		}
+		if n < 0 {
+			z := orbit[len(orbit)-1]
+			long, _ := a.orbit(v, x, y, settleIterations+maxPeriod, 0, 2)
+			p, _ := orbitPeriod(long)
+			return Sample{
+				Count:      iterations,
+				Iterations: iterations,
+				Z:          z,
+				Root:       -1,
+				Period:     p,
+			}
+		}
+		s := Sample{
+			Count:      n,
+			Iterations: smoothIteration(n, orbit[n], 2, 2),
+			Escaped:    true,
+			Z:          orbit[n],
+			Root:       -1,
+		}
+		if mandelbrot {
+			o := deOrbitHP(orbit, len(orbit), math.Inf(1))
+			s.Z = o.z
+			s.Distance = o.distance()
+		}
+		return s
+	}
+}
+
+// Samples z = z^d + c, Mandelbrot or Julia-style as in GetMultibrotFunc.
+func GetMultibrotSampleFunc(d complex128, julia bool, k complex128) SampleFunc {
+	const iterations = 100
+	// Escaped orbits are followed this much further, out to deRadius, to
+	// sharpen the distance estimate.
+	const extra = 20
+
+	pow, dpow := powFunc(d), powFunc(d-1)
+	step := func(z, c complex128) complex128 {
+		return pow(z) + c
+	}
+	radius := escapeRadius(d)
+	deRadius := math.Max(radius, 1000)
+	sample := func(z, c complex128) Sample {
+		var dz complex128
+		if julia {
+			dz = 1
+		}
+		next := func() {
+			if julia {
+				dz = d * dpow(z) * dz
+			} else {
+				dz = d*dpow(z)*dz + 1
+			}
+			z = pow(z) + c
+		}
+		for n := 0; n < iterations; n++ {
+			next()
+			if cmplx.Abs(z) <= radius {
+				continue
+			}
+			nu := smoothIteration(n, z, radius, d)
+			for i := 0; i < extra && cmplx.Abs(z) <= deRadius; i++ {
+				next()
+			}
+			return Sample{
+				Count:      n,
+				Iterations: nu,
+				Escaped:    true,
+				Z:          z,
+				Distance:   deOrbit{z: z, dz: dz}.distance(),
+				Root:       -1,
+			}
+		}
+		p, _ := cyclePeriod(z, c, step)
+		return Sample{
+			Count:      iterations,
+			Iterations: iterations,
+			Z:          z,
+			Root:       -1,
+			Period:     p,
+		}
+	}
+
+	if julia {
+		return func(z complex128) Sample {
+			return sample(z, k)
+		}
+	}
+	return func(c complex128) Sample {
+		return sample(0, c)
+	}
+}
+
+// Samples the Newton iterates of each point.
+func (n NewtonSystem) Sample() SampleFunc {
+	const iterations = 200
+	const tolerance = 0.001
+
+	a, f, d := n.a, n.pair.f, n.pair.d
+	return func(z complex128) Sample {
+		for i := 0; i < iterations; i++ {
+			numerator := f(z)
+			z = z - a*(numerator/d(z))
+			if r := cmplx.Abs(numerator); r < tolerance {
+				return Sample{
+					Count:      i,
+					Iterations: convergedIteration(i, r, tolerance),
+					Escaped:    true,
+					Z:          z,
+				}
+			}
+		}
+		return Sample{
+			Count:      iterations,
+			Iterations: iterations,
+			Z:          z,
+			Root:       -1,
+		}
+	}
+}
+
+// How the colouring stage paints a Buffer.
+type Coloring struct {
+	// "escape" (the default) bands the exterior by escape count like the
+	// MandelFuncs do, "smooth" blends the bands, "distance" draws
+	// boundaries Thickness pixels wide, "histogram" spreads the palette
+	// evenly over the frame and "root" colours Newton renders by the
+	// root each point converges to.
+	Mode string
+	// "modulus" or "period". The interior is black otherwise.
+	Interior  string
+	Thickness float64
+	Colorized bool
+}
+
+// Sorted values to rank against.
+type ranking []float64
+
+// The fraction of the ranking below v.
+func (r ranking) rank(v float64) float64 {
+	return float64(sort.SearchFloat64s(r, v)) / float64(len(r))
+}
+
+// Hue by root, darkening the longer the orbit took to converge.
+func rootColor(root int, nu float64, colorized bool) color.Color {
+	const golden = 137.50776
+	v := math.Max(1-nu/30, 0.15)
+	if colorized {
+		return colorful.Hsv(math.Mod(golden*float64(root), 360), 0.7, v)
+	}
+	return color.Gray{uint8(255 * v * (0.4 + 0.6*float64((root*3)%5)/4))}
+}
+
+func (c Coloring) interior(s Sample) color.Color {
+	switch c.Interior {
+	case "modulus":
+		return modulusColor(s.Z, c.Colorized)
+	case "period":
+		if s.Period > 0 {
+			return indexColor(s.Period, c.Colorized)
+		}
+	}
+	return color.Black
+}
+
+func ColorBuffer(b *Buffer, c Coloring) image.Image {
+	thickness := c.Thickness
+	if thickness <= 0 {
+		thickness = 1
+	}
+	pixelSize := b.PixelSize()
+	if c.Mode == "distance" && !b.hasDistance() {
+		c.Mode = "escape"
+	}
+	var r ranking
+	if c.Mode == "histogram" {
+		for i, v := range b.Iterations {
+			if b.Escaped[i] {
+				r = append(r, float64(v))
+			}
+		}
+		sort.Float64s(r)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, b.Width, b.Height))
+	for py := 0; py < b.Height; py++ {
+		for px := 0; px < b.Width; px++ {
+			s := b.At(px, py)
+			var col color.Color
+			switch {
+			case !s.Escaped:
+				col = c.interior(s)
+			case c.Mode == "smooth":
+				col = smoothColor(s.Iterations, c.Colorized)
+			case c.Mode == "distance":
+				col = distanceColor(s.Distance, pixelSize, thickness, c.Colorized)
+			case c.Mode == "histogram":
+				col = rankColor(r.rank(s.Iterations), c.Colorized)
+			case c.Mode == "root" && s.Root >= 0:
+				col = rootColor(s.Root, s.Iterations, c.Colorized)
+			default:
+				col = escapeColor(uint8(s.Count), c.Colorized)
+			}
+			img.Set(px, py, col)
+		}
+	}
+	return img
+}
@@ -0,0 +1,147 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func testFrame(cx, cy, boundary float64) FrameInfo {
+	return ConstructFrameInfo(
+		boundary,
+		cx-boundary, cy-boundary,
+		cx+boundary, cy+boundary,
+		cx, cy,
+	)
+}
+
+func sameImage(t *testing.T, name string, got, want image.Image) {
+	t.Helper()
+	b := want.Bounds()
+	if got.Bounds() != b {
+		t.Fatalf("%s: bounds %v, want %v", name, got.Bounds(), b)
+	}
+	diff := 0
+	for py := b.Min.Y; py < b.Max.Y; py++ {
+		for px := b.Min.X; px < b.Max.X; px++ {
+			r0, g0, b0, a0 := got.At(px, py).RGBA()
+			r1, g1, b1, a1 := want.At(px, py).RGBA()
+			if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+				diff++
+			}
+		}
+	}
+	if diff > 0 {
+		t.Errorf("%s: %d of %d pixels differ", name, diff, b.Dx()*b.Dy())
+	}
+}
+
+// Escape colouring of a buffer paints what the variant's MandelFunc does.
+func TestColorBufferMatchesMandelFunc(t *testing.T) {
+	const size = 64
+
+	tests := []struct {
+		fractalType string
+		f           FrameInfo
+	}{
+		{"burningShip", testFrame(-0.5, -0.5, 1.5)},
+		{"tricorn", testFrame(0, 0, 2)},
+		{"celtic", testFrame(-0.5, 0, 1.5)},
+		{"perpendicular", testFrame(-0.5, 0, 1.5)},
+		{"buffalo", testFrame(-0.5, -0.5, 1.5)},
+	}
+	for _, test := range tests {
+		for _, colorized := range []bool{false, true} {
+			b := <-RenderBuffer(
+				size, size, test.f, GetSampleFunc(test.fractalType),
+			)
+			got := ColorBuffer(b, Coloring{Mode: "escape", Colorized: colorized})
+			want := <-RenderMFrame(
+				size, size, test.f, GetMandelFunc(test.fractalType, colorized),
+			)
+			sameImage(t, test.fractalType, got, want)
+		}
+	}
+}
+
+// A buffer read back from WriteBuffer recolours exactly as the original.
+func TestBufferRoundTrip(t *testing.T) {
+	const size = 32
+
+	buffers := map[string]*Buffer{
+		"mandelbrot": <-RenderBuffer(
+			size, size, testFrame(-0.5, 0, 1.5), GetSampleFunc("mandelbrot"),
+		),
+		"newton": <-RenderBuffer(
+			size, size, testFrame(0, 0, 2), NewtonSystemOne.Sample(),
+		),
+	}
+	colorings := []Coloring{
+		{Mode: "escape"},
+		{Mode: "smooth", Colorized: true},
+		{Mode: "distance", Thickness: 2},
+		{Mode: "histogram", Interior: "period"},
+		{Mode: "root", Interior: "modulus", Colorized: true},
+	}
+	for name, b := range buffers {
+		buf := new(bytes.Buffer)
+		if err := WriteBuffer(buf, b); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data := buf.Bytes()
+		read, err := ReadBuffer(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// Compared as bytes, since Newton samples can hold NaN.
+		again := new(bytes.Buffer)
+		if err := WriteBuffer(again, read); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(again.Bytes(), data) {
+			t.Errorf("%s: buffer changed over the round trip", name)
+			continue
+		}
+		for _, c := range colorings {
+			sameImage(t, name+" "+c.Mode, ColorBuffer(read, c), ColorBuffer(b, c))
+		}
+	}
+}
+
+func TestReadBufferRejects(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":     nil,
+		"magic":     []byte("FHBUF999"),
+		"truncated": []byte(bufferMagic + "\x02\x00\x00\x00"),
+		"too large": append([]byte(bufferMagic), 0, 0, 1, 0, 0, 0, 1, 0),
+	}
+	for name, data := range tests {
+		if _, err := ReadBuffer(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: ReadBuffer gave no error", name)
+		}
+	}
+}
+
+// Buffers without distance estimates fall back to escape colouring rather
+// than painting the whole exterior black.
+func TestColorBufferDistanceFallback(t *testing.T) {
+	const size = 32
+
+	f := testFrame(-0.5, 0, 1.5)
+	variant := <-RenderBuffer(size, size, f, GetSampleFunc("tricorn"))
+	sameImage(t, "tricorn",
+		ColorBuffer(variant, Coloring{Mode: "distance"}),
+		ColorBuffer(variant, Coloring{Mode: "escape"}),
+	)
+
+	mandelbrot := <-RenderBuffer(size, size, f, GetSampleFunc("mandelbrot"))
+	if !mandelbrot.hasDistance() {
+		t.Fatal("mandelbrot buffer has no distance estimates")
+	}
+	distance := ColorBuffer(mandelbrot, Coloring{Mode: "distance"})
+	escape := ColorBuffer(mandelbrot, Coloring{Mode: "escape"})
+	if reflect.DeepEqual(distance, escape) {
+		t.Error("mandelbrot distance colouring fell back to escape colouring")
+	}
+}
@@ -0,0 +1,146 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Exterior distance estimation for z = z^d + c. The derivative of the orbit
+// is carried along with it, which gives an estimate of how far each point
+// is from the set: thin filaments that fall between pixel centres still
+// show up as lines.
+
+// What the orbit of one point left behind.
+type deOrbit struct {
+	n       int
+	z       complex128
+	dz      complex128
+	escaped bool
+}
+
+// Returns |z| log|z| / |dz|, scaled so that it is close to the distance in
+// the complex plane for d = 2.
+func (o deOrbit) distance() float64 {
+	r := cmplx.Abs(o.z)
+	return 0.5 * r * math.Log(r) / cmplx.Abs(o.dz)
+}
+
+// Mandelbrot-style orbits differentiate with respect to c, Julia-style
+// orbits with respect to the starting point.
+func deIterator(d complex128, julia bool) func(z, c complex128) deOrbit {
+	const iterations = 200
+
+	// A large radius keeps the estimate accurate.
+	radius := math.Max(escapeRadius(d), 1000)
+	pow, dpow := powFunc(d), powFunc(d-1)
+	return func(z, c complex128) deOrbit {
+		var dz complex128
+		if julia {
+			dz = 1
+		}
+		for n := 0; n < iterations; n++ {
+			if julia {
+				dz = d * dpow(z) * dz
+			} else {
+				dz = d*dpow(z)*dz + 1
+			}
+			z = pow(z) + c
+			if cmplx.Abs(z) > radius {
+				return deOrbit{n, z, dz, true}
+			}
+		}
+		return deOrbit{iterations, z, dz, false}
+	}
+}
+
+// Carries the derivative along an orbit of z = z^2 + c that was followed
+// at high precision, stopping where the orbit passes radius or after
+// iterations points.
+func deOrbitHP(orbit []complex128, iterations int, radius float64) deOrbit {
+	var z, dz complex128
+	for n, next := range orbit {
+		if n == iterations {
+			break
+		}
+		dz = 2*z*dz + 1
+		z = next
+		if cmplx.Abs(z) > radius {
+			return deOrbit{n, z, dz, true}
+		}
+	}
+	return deOrbit{iterations, z, dz, false}
+}
+
+// Follows the orbit of c for distance estimation at high precision.
+func deIteratorHP(a *Arithmetic, cR, cI *big.Float) deOrbit {
+	const iterations = 200
+	const radius = 1000
+
+	orbit, _ := a.orbit(
+		variants["mandelbrot"], cR, cI, iterations, iterations, radius,
+	)
+	return deOrbitHP(orbit, iterations, radius)
+}
+
+// Points closer than thickness pixels to the set are drawn dark and the
+// exterior brightens with distance. Colourised renders also take their hue
+// from the distance, in octaves of the pixel size.
+func distanceColor(
+	dist, pixelSize, thickness float64, colorized bool,
+) color.Color {
+	const contrast = 30
+
+	if math.IsNaN(dist) || dist <= 0 {
+		return color.Black
+	}
+	v := math.Pow(math.Min(dist/(thickness*pixelSize), 1), 0.25)
+	if colorized {
+		hue := math.Mod(contrast*math.Log2(dist/pixelSize), 360)
+		if hue < 0 {
+			hue += 360
+		}
+		return colorful.Hsv(hue, 0.6, v)
+	}
+	return color.Gray{uint8(255 * v)}
+}
+
+// pixelSize is the width of a pixel in the complex plane and thickness the
+// width of boundary lines in pixels.
+func GetDistanceFunc(
+	d complex128, julia bool, k complex128,
+	pixelSize, thickness float64,
+	colorized bool,
+) MandelFunc {
+	iterate := deIterator(d, julia)
+	shade := func(o deOrbit) color.Color {
+		if !o.escaped {
+			return color.Black
+		}
+		return distanceColor(o.distance(), pixelSize, thickness, colorized)
+	}
+	if julia {
+		return func(z complex128) color.Color {
+			return shade(iterate(z, k))
+		}
+	}
+	return func(c complex128) color.Color {
+		return shade(iterate(0, c))
+	}
+}
+
+// Like GetDistanceFunc for the standard Mandelbrot set at high precision.
+// The derivative only sets the colour, so it is carried in float64.
+func GetDistanceFuncHP(
+	a *Arithmetic, pixelSize, thickness float64, colorized bool,
+) MandelFuncHP {
+	return func(cR, cI *big.Float) color.Color {
+		o := deIteratorHP(a, cR, cI)
+		if !o.escaped {
+			return color.Black
+		}
+		return distanceColor(o.distance(), pixelSize, thickness, colorized)
+	}
+}
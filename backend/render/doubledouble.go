@@ -0,0 +1,120 @@
+package render
+
+import (
+	"math"
+	"math/big"
+)
+
+// Double-double arithmetic: a value held as the unevaluated sum of two
+// float64s, the second below the last bit of the first, which gives about
+// 106 bits of mantissa at a small multiple of the cost of float64. It
+// covers the zooms between float64 running out and big.Float being
+// needed, where big.Float would spend most of its time allocating.
+
+// Bits of mantissa a doubleDouble holds.
+const DoubleDoubleBits = 106
+
+type doubleDouble struct {
+	hi, lo float64
+}
+
+// The sum of a and b, and the rounding error of it.
+func twoSum(a, b float64) (float64, float64) {
+	s := a + b
+	bb := s - a
+	return s, (a - (s - bb)) + (b - bb)
+}
+
+// Like twoSum, for |a| >= |b|.
+func quickTwoSum(a, b float64) (float64, float64) {
+	s := a + b
+	return s, b - (s - a)
+}
+
+// The product of a and b, and the rounding error of it.
+func twoProd(a, b float64) (float64, float64) {
+	p := a * b
+	return p, math.FMA(a, b, -p)
+}
+
+func newDoubleDouble(x *big.Float) doubleDouble {
+	hi, _ := x.Float64()
+	lo, _ := new(big.Float).Sub(x, big.NewFloat(hi)).Float64()
+	return doubleDouble{hi, lo}
+}
+
+func (a doubleDouble) add(b doubleDouble) doubleDouble {
+	s, e := twoSum(a.hi, b.hi)
+	t, f := twoSum(a.lo, b.lo)
+	e += t
+	s, e = quickTwoSum(s, e)
+	e += f
+	s, e = quickTwoSum(s, e)
+	return doubleDouble{s, e}
+}
+
+func (a doubleDouble) sub(b doubleDouble) doubleDouble {
+	return a.add(b.neg())
+}
+
+func (a doubleDouble) mul(b doubleDouble) doubleDouble {
+	p, e := twoProd(a.hi, b.hi)
+	e += a.hi*b.lo + a.lo*b.hi
+	p, e = quickTwoSum(p, e)
+	return doubleDouble{p, e}
+}
+
+// Multiplying by a power of two is exact.
+func (a doubleDouble) double() doubleDouble {
+	return doubleDouble{2 * a.hi, 2 * a.lo}
+}
+
+func (a doubleDouble) neg() doubleDouble {
+	return doubleDouble{-a.hi, -a.lo}
+}
+
+func (a doubleDouble) abs() doubleDouble {
+	if a.hi < 0 {
+		return a.neg()
+	}
+	return a
+}
+
+// Like orbitHP, in double-double.
+func (v variant) orbitDD(
+	cR, cI *big.Float, iterations, extra int, radius float64,
+) ([]complex128, int) {
+	cx, cy := newDoubleDouble(cR), newDoubleDouble(cI)
+	var x, y doubleDouble
+	orbit := make([]complex128, 0, iterations)
+	escape := -1
+	for n := 0; escape >= 0 || n < iterations; n++ {
+		if v.absX {
+			x = x.abs()
+		}
+		if v.absY {
+			y = y.abs()
+		}
+		if v.conj {
+			y = y.neg()
+		}
+		re, im := x.mul(x).sub(y.mul(y)), x.mul(y).double()
+		if v.absRe {
+			re = re.abs()
+		}
+		if v.negIm {
+			im = im.neg()
+		}
+		x, y = re.add(cx), im.add(cy)
+
+		z := complex(x.hi, y.hi)
+		orbit = append(orbit, z)
+		if escape < 0 && real(z)*real(z)+imag(z)*imag(z) > 4 {
+			escape = n
+		}
+		if escape >= 0 && (n-escape >= extra || math.Hypot(x.hi, y.hi) > radius) {
+			break
+		}
+	}
+	return orbit, escape
+}
@@ -0,0 +1,90 @@
+package render
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// The sum of the float64s, exactly.
+func exactSum(v ...float64) *big.Float {
+	sum := new(big.Float).SetPrec(4096)
+	for _, x := range v {
+		sum.Add(sum, big.NewFloat(x))
+	}
+	return sum
+}
+
+func TestTwoSumTwoProd(t *testing.T) {
+	tests := [][2]float64{
+		{1, 1e-20},
+		{0.1, 0.2},
+		{1e150, -3e149},
+		{3, 1.0 / 3},
+		{-2.5, math.Nextafter(2.5, 3)},
+		{math.Pi, math.E},
+	}
+	for _, test := range tests {
+		a, b := test[0], test[1]
+		s, e := twoSum(a, b)
+		if exactSum(s, e).Cmp(exactSum(a, b)) != 0 {
+			t.Errorf("twoSum(%g, %g) = %g + %g, not exact", a, b, s, e)
+		}
+		p, e := twoProd(a, b)
+		want := new(big.Float).SetPrec(4096).Mul(big.NewFloat(a), big.NewFloat(b))
+		if exactSum(p, e).Cmp(want) != 0 {
+			t.Errorf("twoProd(%g, %g) = %g + %g, not exact", a, b, p, e)
+		}
+	}
+}
+
+func TestDoubleDouble(t *testing.T) {
+	parse := func(s string) *big.Float {
+		v, _, err := big.ParseFloat(s, 10, DoubleDoubleBits, big.ToNearestEven)
+		if err != nil {
+			panic(err)
+		}
+		return v
+	}
+	values := []*big.Float{
+		parse("1.00000000000000000000000000001"),
+		parse("-0.743643887037158704752191506114774"),
+		parse("0.131825904205311970493132056385139"),
+		parse("1e-25"),
+		parse("-2"),
+	}
+	value := func(a doubleDouble) *big.Float {
+		return exactSum(a.hi, a.lo)
+	}
+	// Relative error allowed in each operation.
+	within := func(got doubleDouble, want *big.Float) bool {
+		diff := new(big.Float).Sub(value(got), want)
+		diff.Abs(diff)
+		bound := new(big.Float).Abs(want)
+		bound.SetMantExp(bound, -100)
+		return diff.Cmp(bound) <= 0
+	}
+
+	for _, x := range values {
+		a := newDoubleDouble(x)
+		if value(a).Cmp(x) != 0 {
+			t.Errorf("newDoubleDouble(%s) = %g + %g, not exact", x.Text('g', 35), a.hi, a.lo)
+		}
+		if math.Abs(a.lo) > math.Abs(a.hi)*0x1p-52 {
+			t.Errorf("newDoubleDouble(%s) = %g + %g, not normalised", x.Text('g', 35), a.hi, a.lo)
+		}
+		for _, y := range values {
+			b := newDoubleDouble(y)
+			exact := new(big.Float).SetPrec(4096)
+			if got := a.add(b); !within(got, exact.Add(x, y)) {
+				t.Errorf("%s + %s = %s", x.Text('g', 35), y.Text('g', 35), value(got).Text('g', 35))
+			}
+			if got := a.sub(b); !within(got, exact.Sub(x, y)) {
+				t.Errorf("%s - %s = %s", x.Text('g', 35), y.Text('g', 35), value(got).Text('g', 35))
+			}
+			if got := a.mul(b); !within(got, exact.Mul(x, y)) {
+				t.Errorf("%s * %s = %s", x.Text('g', 35), y.Text('g', 35), value(got).Text('g', 35))
+			}
+		}
+	}
+}
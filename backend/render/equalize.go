@@ -0,0 +1,46 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Histogram equalised colouring. Each value is coloured by its rank among
+// the values in the frame rather than by the value itself, so the palette
+// is spread evenly over the frame at any zoom.
+
+func rankColor(rank float64, colorized bool) color.Color {
+	if colorized {
+		return colorful.Hsv(math.Mod(240+300*rank, 360), 0.7, 0.4+0.6*rank)
+	}
+	return color.Gray{uint8(255 * (1 - rank))}
+}
+
+// Colours f by rank. Values of limit or more, the iteration limit of the
+// ValueFunc that filled f, did not escape or converge and are painted
+// black.
+func EqualizeField(f *Field, limit float64, colorized bool) image.Image {
+	r := make(ranking, 0, len(f.Values))
+	for _, v := range f.Values {
+		if v < limit && !math.IsInf(v, 0) {
+			r = append(r, v)
+		}
+	}
+	sort.Float64s(r)
+
+	img := image.NewRGBA(image.Rect(0, 0, f.Width, f.Height))
+	for py := 0; py < f.Height; py++ {
+		for px := 0; px < f.Width; px++ {
+			v := f.At(px, py)
+			if !(v < limit) || math.IsInf(v, 0) {
+				img.Set(px, py, color.Black)
+				continue
+			}
+			img.Set(px, py, rankColor(r.rank(v), colorized))
+		}
+	}
+	return img
+}
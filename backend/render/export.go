@@ -0,0 +1,177 @@
+package render
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Export of Buffers for analysis in other tools. Row py of every format
+// holds the points with imaginary part ymin + py*(ymax - ymin)/height and
+// column px those with real part xmin + px*(xmax - xmin)/width, exactly
+// as they were sampled.
+
+// Frames with more pixels than this are refused as CSV.
+const MaxCSVPixels = 512 * 512
+
+// Writes b as a NumPy .npy file holding a (height, width) array of records
+// with one field per quantity. z is complex64.
+func WriteNPY(w io.Writer, b *Buffer) error {
+	const recordSize = 2 + 4 + 1 + 8 + 4 + 2 + 2
+
+	header := fmt.Sprintf(
+		"{'descr': [('count', '<i2'), ('iterations', '<f4'), "+
+			"('escaped', '|b1'), ('z', '<c8'), ('distance', '<f4'), "+
+			"('root', '<i2'), ('period', '<i2')], "+
+			"'fortran_order': False, 'shape': (%d, %d), }",
+		b.Height, b.Width,
+	)
+	// The magic, version and length take 10 bytes, and the data has to
+	// start on a multiple of 64.
+	for (10+len(header)+1)%64 != 0 {
+		header += " "
+	}
+	header += "\n"
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("\x93NUMPY\x01\x00")
+	binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+	bw.WriteString(header)
+
+	var record [recordSize]byte
+	le := binary.LittleEndian
+	for i := range b.Count {
+		le.PutUint16(record[0:], uint16(b.Count[i]))
+		le.PutUint32(record[2:], math.Float32bits(b.Iterations[i]))
+		record[6] = 0
+		if b.Escaped[i] {
+			record[6] = 1
+		}
+		le.PutUint32(record[7:], math.Float32bits(real(b.Z[i])))
+		le.PutUint32(record[11:], math.Float32bits(imag(b.Z[i])))
+		le.PutUint32(record[15:], math.Float32bits(b.Distance[i]))
+		le.PutUint16(record[19:], uint16(b.Root[i]))
+		le.PutUint16(record[21:], uint16(b.Period[i]))
+		if _, err := bw.Write(record[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Describes the file written by WriteRaw.
+type RawHeader struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	XMin   float64 `json:"xmin"`
+	XMax   float64 `json:"xmax"`
+	YMin   float64 `json:"ymin"`
+	YMax   float64 `json:"ymax"`
+	// Always "float32", little-endian.
+	Type string `json:"type"`
+	// One plane of Width*Height values per channel, row by row, in this
+	// order.
+	Channels []string `json:"channels"`
+}
+
+func NewRawHeader(b *Buffer) RawHeader {
+	_, xmin, ymin, xmax, ymax, _, _ := b.Frame.Read()
+	return RawHeader{
+		Width:  b.Width,
+		Height: b.Height,
+		XMin:   xmin,
+		XMax:   xmax,
+		YMin:   ymin,
+		YMax:   ymax,
+		Type:   "float32",
+		Channels: []string{
+			"count", "iterations", "escaped", "z.real", "z.imag",
+			"distance", "root", "period",
+		},
+	}
+}
+
+// Writes each quantity of b as a plane of little-endian float32s, as
+// described by NewRawHeader. escaped is 0 or 1.
+func WriteRaw(w io.Writer, b *Buffer) error {
+	planes := []func(i int) float32{
+		func(i int) float32 { return float32(b.Count[i]) },
+		func(i int) float32 { return b.Iterations[i] },
+		func(i int) float32 {
+			if b.Escaped[i] {
+				return 1
+			}
+			return 0
+		},
+		func(i int) float32 { return real(b.Z[i]) },
+		func(i int) float32 { return imag(b.Z[i]) },
+		func(i int) float32 { return b.Distance[i] },
+		func(i int) float32 { return float32(b.Root[i]) },
+		func(i int) float32 { return float32(b.Period[i]) },
+	}
+
+	bw := bufio.NewWriter(w)
+	var v [4]byte
+	for _, plane := range planes {
+		for i := range b.Count {
+			binary.LittleEndian.PutUint32(v[:], math.Float32bits(plane(i)))
+			if _, err := bw.Write(v[:]); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Refuses frames of width by height pixels that are too large for CSV.
+func CheckCSVSize(width, height int) error {
+	if width*height > MaxCSVPixels {
+		return fmt.Errorf(
+			"%dx%d is too large for csv, which takes up to %d pixels",
+			width, height, MaxCSVPixels,
+		)
+	}
+	return nil
+}
+
+// Writes one row per pixel with its position, the point sampled and each
+// quantity.
+func WriteCSV(w io.Writer, b *Buffer) error {
+	if err := CheckCSVSize(b.Width, b.Height); err != nil {
+		return err
+	}
+	_, xmin, ymin, xmax, ymax, _, _ := b.Frame.Read()
+	f32 := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+	f64 := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("px,py,re,im,count,iterations,escaped,z.real,z.imag,distance,root,period\n")
+	for py := 0; py < b.Height; py++ {
+		y := float64(py)/float64(b.Height)*(ymax-ymin) + ymin
+		for px := 0; px < b.Width; px++ {
+			x := float64(px)/float64(b.Width)*(xmax-xmin) + xmin
+			i := py*b.Width + px
+			escaped := "0"
+			if b.Escaped[i] {
+				escaped = "1"
+			}
+			_, err := fmt.Fprintf(bw, "%d,%d,%s,%s,%d,%s,%s,%s,%s,%s,%d,%d\n",
+				px, py, f64(x), f64(y),
+				b.Count[i], f32(b.Iterations[i]), escaped,
+				f32(real(b.Z[i])), f32(imag(b.Z[i])),
+				f32(b.Distance[i]), b.Root[i], b.Period[i],
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,147 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// A 3x2 buffer over x in [-2, 1], y in [-1, 1] with a distinct sample in
+// every pixel.
+func exportBuffer() *Buffer {
+	b := newBuffer(3, 2, ConstructFrameInfo(1.5, -2, -1, 1, 1, -0.5, 0))
+	for i := range b.Count {
+		b.set(i, Sample{
+			Count:      i,
+			Iterations: float64(i) + 0.5,
+			Escaped:    i%2 == 1,
+			Z:          complex(float64(i), -1),
+			Distance:   0.25 * float64(i),
+			Root:       i - 1,
+			Period:     2 * i,
+		})
+	}
+	return b
+}
+
+func TestWriteNPY(t *testing.T) {
+	b := exportBuffer()
+	buf := new(bytes.Buffer)
+	if err := WriteNPY(buf, b); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if magic := string(data[:8]); magic != "\x93NUMPY\x01\x00" {
+		t.Fatalf("magic %q", magic)
+	}
+	n := int(binary.LittleEndian.Uint16(data[8:10]))
+	if (10+n)%64 != 0 {
+		t.Errorf("data starts at %d, not a multiple of 64", 10+n)
+	}
+	header := string(data[10 : 10+n])
+	want := "{'descr': [('count', '<i2'), ('iterations', '<f4'), " +
+		"('escaped', '|b1'), ('z', '<c8'), ('distance', '<f4'), " +
+		"('root', '<i2'), ('period', '<i2')], " +
+		"'fortran_order': False, 'shape': (2, 3), }"
+	if strings.TrimRight(header, " \n") != want || !strings.HasSuffix(header, "\n") {
+		t.Errorf("header %q", header)
+	}
+
+	records := data[10+n:]
+	const recordSize = 23
+	if len(records) != 6*recordSize {
+		t.Fatalf("%d bytes of records, want %d", len(records), 6*recordSize)
+	}
+	// Pixel (0, 1): count 3, iterations 3.5, escaped, z = 3 - i, distance
+	// 0.75, root 2, period 6.
+	golden := []byte{
+		0x03, 0x00,
+		0x00, 0x00, 0x60, 0x40,
+		0x01,
+		0x00, 0x00, 0x40, 0x40, 0x00, 0x00, 0x80, 0xbf,
+		0x00, 0x00, 0x40, 0x3f,
+		0x02, 0x00,
+		0x06, 0x00,
+	}
+	if got := records[3*recordSize : 4*recordSize]; !bytes.Equal(got, golden) {
+		t.Errorf("record 3 is % x, want % x", got, golden)
+	}
+	// Pixel (0, 0) has root -1.
+	if root := records[19:21]; !bytes.Equal(root, []byte{0xff, 0xff}) {
+		t.Errorf("root of record 0 is % x", root)
+	}
+}
+
+func TestWriteRaw(t *testing.T) {
+	b := exportBuffer()
+	header := NewRawHeader(b)
+	if header.Width != 3 || header.Height != 2 ||
+		header.XMin != -2 || header.XMax != 1 ||
+		header.YMin != -1 || header.YMax != 1 ||
+		header.Type != "float32" {
+		t.Errorf("header %+v", header)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteRaw(buf, b); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(data) != 4*6*len(header.Channels) {
+		t.Fatalf("%d bytes for %d channels", len(data), len(header.Channels))
+	}
+	at := func(channel, i int) float32 {
+		v := binary.LittleEndian.Uint32(data[4*(channel*6+i):])
+		return math.Float32frombits(v)
+	}
+	want := map[string][]float32{
+		"count":      {0, 1, 2, 3, 4, 5},
+		"iterations": {0.5, 1.5, 2.5, 3.5, 4.5, 5.5},
+		"escaped":    {0, 1, 0, 1, 0, 1},
+		"z.real":     {0, 1, 2, 3, 4, 5},
+		"z.imag":     {-1, -1, -1, -1, -1, -1},
+		"distance":   {0, 0.25, 0.5, 0.75, 1, 1.25},
+		"root":       {-1, 0, 1, 2, 3, 4},
+		"period":     {0, 2, 4, 6, 8, 10},
+	}
+	for c, name := range header.Channels {
+		for i, v := range want[name] {
+			if got := at(c, i); got != v {
+				t.Errorf("%s[%d] = %g, want %g", name, i, got, v)
+			}
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := WriteCSV(buf, exportBuffer()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{
+		"px,py,re,im,count,iterations,escaped,z.real,z.imag,distance,root,period",
+		"0,0,-2,-1,0,0.5,0,0,-1,0,-1,0",
+		"1,0,-1,-1,1,1.5,1,1,-1,0.25,0,2",
+		"2,0,0,-1,2,2.5,0,2,-1,0.5,1,4",
+		"0,1,-2,0,3,3.5,1,3,-1,0.75,2,6",
+		"1,1,-1,0,4,4.5,0,4,-1,1,3,8",
+		"2,1,0,0,5,5.5,1,5,-1,1.25,4,10",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d is %q, want %q", i, lines[i], want[i])
+		}
+	}
+
+	large := newBuffer(1024, 1024, testFrame(0, 0, 2))
+	if err := WriteCSV(new(bytes.Buffer), large); err == nil {
+		t.Error("WriteCSV took a frame over MaxCSVPixels")
+	}
+}
@@ -0,0 +1,188 @@
+package render
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Perturbation with extended exponent floats. The orbit of the centre of
+// the frame is followed once at full precision, and each pixel follows
+// only its offset from that orbit. The offsets are far smaller than the
+// orbit but need no more than float64's mantissa, so they are held with an
+// exponent of their own that cannot underflow however deep the zoom goes.
+
+// A float64 mantissa with an exponent of its own: m * 2^e. m is 0 or in
+// [0.5, 1).
+type floatExp struct {
+	m float64
+	e int
+}
+
+func newFloatExp(m float64, e int) floatExp {
+	if m == 0 {
+		return floatExp{}
+	}
+	f, x := math.Frexp(m)
+	return floatExp{f, e + x}
+}
+
+func bigFloatExp(x *big.Float) floatExp {
+	mant := new(big.Float)
+	e := x.MantExp(mant)
+	m, _ := mant.Float64()
+	return floatExp{m, e}
+}
+
+// Rounds a to float64, which may underflow to 0.
+func (a floatExp) float64() float64 {
+	return math.Ldexp(a.m, a.e)
+}
+
+func (a floatExp) add(b floatExp) floatExp {
+	if a.m == 0 {
+		return b
+	}
+	if b.m == 0 {
+		return a
+	}
+	if a.e < b.e {
+		a, b = b, a
+	}
+	// b is entirely below the last bit of a.
+	if a.e-b.e > 64 {
+		return a
+	}
+	return newFloatExp(a.m+math.Ldexp(b.m, b.e-a.e), a.e)
+}
+
+func (a floatExp) sub(b floatExp) floatExp {
+	return a.add(floatExp{-b.m, b.e})
+}
+
+func (a floatExp) mul(b floatExp) floatExp {
+	return newFloatExp(a.m*b.m, a.e+b.e)
+}
+
+// Whether a < b, for a and b that are not negative.
+func (a floatExp) less(b floatExp) bool {
+	switch {
+	case b.m == 0:
+		return false
+	case a.m == 0:
+		return true
+	case a.e != b.e:
+		return a.e < b.e
+	}
+	return a.m < b.m
+}
+
+type complexExp struct {
+	re, im floatExp
+}
+
+func newComplexExp(z complex128) complexExp {
+	return complexExp{newFloatExp(real(z), 0), newFloatExp(imag(z), 0)}
+}
+
+func (a complexExp) add(b complexExp) complexExp {
+	return complexExp{a.re.add(b.re), a.im.add(b.im)}
+}
+
+func (a complexExp) mul(b complexExp) complexExp {
+	return complexExp{
+		a.re.mul(b.re).sub(a.im.mul(b.im)),
+		a.re.mul(b.im).add(a.im.mul(b.re)),
+	}
+}
+
+// |a|^2
+func (a complexExp) norm() floatExp {
+	return a.re.mul(a.re).add(a.im.mul(a.im))
+}
+
+func (a complexExp) complex128() complex128 {
+	return complex(a.re.float64(), a.im.float64())
+}
+
+// The orbit of z = z^2 + c that pixel orbits are perturbed from.
+type reference struct {
+	cR, cI *big.Float
+	// Starts from z = 0.
+	orbit []complex128
+}
+
+// Follows the reference orbit for frame f from the centre, or from
+// whichever point of a coarse grid over f lasts longest if the centre
+// escapes within iterations.
+func newReference(f FrameInfoHP, iterations int) *reference {
+	const grid = 8
+
+	_, xmin, ymin, xmax, ymax, cx, cy := f.Read()
+	var best *reference
+	for i := -1; i < grid*grid; i++ {
+		cR, cI := cx, cy
+		if i >= 0 {
+			cR = lerpHP(xmin, xmax, (float64(i%grid)+0.5)/grid)
+			cI = lerpHP(ymin, ymax, (float64(i/grid)+0.5)/grid)
+		}
+		orbit, n := variants["mandelbrot"].orbitHP(cR, cI, iterations, 0, 2)
+		if best == nil || len(orbit) >= len(best.orbit) {
+			best = &reference{cR, cI, append([]complex128{0}, orbit...)}
+		}
+		if n < 0 {
+			break
+		}
+	}
+	return best
+}
+
+// The point t of the way from a to b, at their precision.
+func lerpHP(a, b *big.Float, t float64) *big.Float {
+	x := new(big.Float).Sub(b, a)
+	x.Mul(x, big.NewFloat(t))
+	return x.Add(x, a)
+}
+
+// Like orbitHP for the standard Mandelbrot set, following the offset of
+// the orbit of c from the reference orbit. Pixel orbits that come closer
+// to 0 than to the reference are rebased to follow it again from its
+// start, which keeps the offset small without any other check for where
+// perturbation breaks down. The few that outlast the reference are
+// followed in big.Float instead.
+func (r *reference) orbitOf(
+	cR, cI *big.Float, iterations, extra int, radius float64,
+) ([]complex128, int) {
+	dc := complexExp{
+		bigFloatExp(new(big.Float).Sub(cR, r.cR)),
+		bigFloatExp(new(big.Float).Sub(cI, r.cI)),
+	}
+	var d complexExp
+	m := 0
+	orbit := make([]complex128, 0, iterations)
+	escape := -1
+	for n := 0; escape >= 0 || n < iterations; n++ {
+		if m == len(r.orbit)-1 {
+			return variants["mandelbrot"].orbitHP(
+				cR, cI, iterations, extra, radius,
+			)
+		}
+		// d = (2Z + d)d + dc
+		d = newComplexExp(2 * r.orbit[m]).add(d).mul(d).add(dc)
+		m++
+		zx := newComplexExp(r.orbit[m]).add(d)
+
+		z := zx.complex128()
+		orbit = append(orbit, z)
+		if escape < 0 && real(z)*real(z)+imag(z)*imag(z) > 4 {
+			escape = n
+		}
+		if escape >= 0 && (n-escape >= extra || cmplx.Abs(z) > radius) {
+			break
+		}
+		if zx.norm().less(d.norm()) {
+			d, m = zx, 0
+		}
+	}
+	return orbit, escape
+}
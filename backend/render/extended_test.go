@@ -0,0 +1,171 @@
+package render
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"reflect"
+	"testing"
+)
+
+func TestNewFloatExp(t *testing.T) {
+	tests := []struct {
+		m    float64
+		e    int
+		want floatExp
+	}{
+		{0, 0, floatExp{}},
+		{0, 100, floatExp{}},
+		{1, 0, floatExp{0.5, 1}},
+		{3, 0, floatExp{0.75, 2}},
+		{-0.5, 10, floatExp{-0.5, 10}},
+		{0.1, -2000, floatExp{0.8, -2003}},
+		// Subnormal float64s come out normalised.
+		{5e-324, 0, floatExp{0.5, -1073}},
+	}
+	for _, test := range tests {
+		if got := newFloatExp(test.m, test.e); got != test.want {
+			t.Errorf("newFloatExp(%g, %d) = %v, want %v", test.m, test.e, got, test.want)
+		}
+	}
+}
+
+func TestBigFloatExp(t *testing.T) {
+	tests := []struct {
+		x    *big.Float
+		want floatExp
+	}{
+		{big.NewFloat(0), floatExp{}},
+		{big.NewFloat(-6), floatExp{-0.75, 3}},
+		// Far below the smallest float64.
+		{new(big.Float).SetMantExp(big.NewFloat(3), -5000), floatExp{0.75, -4998}},
+	}
+	for _, test := range tests {
+		if got := bigFloatExp(test.x); got != test.want {
+			t.Errorf("bigFloatExp(%s) = %v, want %v", test.x.Text('g', 10), got, test.want)
+		}
+	}
+}
+
+func TestFloatExpArithmetic(t *testing.T) {
+	f := func(m float64, e int) floatExp {
+		return newFloatExp(m, e)
+	}
+	tests := []struct {
+		name      string
+		got, want floatExp
+	}{
+		{"add", f(1.5, 0).add(f(0.25, 0)), f(1.75, 0)},
+		{"add zero", f(0, 0).add(f(3, -2000)), f(3, -2000)},
+		{"add below", f(1, 0).add(f(1, -80)), f(1, 0)},
+		{"add deep", f(1, -3000).add(f(1, -3001)), f(1.5, -3000)},
+		{"cancel", f(1, -3000).sub(f(1, -3000)), floatExp{}},
+		{"sub", f(1, 0).sub(f(0.25, 0)), f(0.75, 0)},
+		{"mul", f(-3, 0).mul(f(0.5, 0)), f(-1.5, 0)},
+		{"mul deep", f(1, -1500).mul(f(1, -1500)), f(1, -3000)},
+		{"mul zero", f(0, 0).mul(f(1, -1500)), floatExp{}},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	if got := f(3, -1100).float64(); got != 0 {
+		t.Errorf("3*2^-1100 rounds to %g, want 0", got)
+	}
+	if got := f(0.3, 5).float64(); got != 0.3*32 {
+		t.Errorf("0.3*2^5 rounds to %g, want %g", got, 0.3*32)
+	}
+
+	ordered := []floatExp{{}, f(1, -3000), f(0.75, -2000), f(1, -2000), f(1, 0)}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			if got := a.less(b); got != (i < j) {
+				t.Errorf("%v.less(%v) = %v", a, b, got)
+			}
+		}
+	}
+}
+
+// Pixel orbits in this frame come closer to 0 than to the reference, so
+// they are rebased, and still escape when their big.Float orbits do.
+func TestReferenceRebasing(t *testing.T) {
+	const (
+		size       = 10
+		iterations = 2000
+		prec       = 128
+	)
+
+	v := func(s string) *big.Float {
+		x, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+		if err != nil {
+			panic(err)
+		}
+		return x
+	}
+	cx, cy, b := v("-1.768778833"), v("-0.001738996"), v("1e-8")
+	f := ConstructFrameInfoHP(
+		b,
+		new(big.Float).Sub(cx, b), new(big.Float).Sub(cy, b),
+		new(big.Float).Add(cx, b), new(big.Float).Add(cy, b),
+		cx, cy,
+	)
+	r := newReference(f, iterations)
+
+	rebased := 0
+	for _, p := range gridHP("-1.768778833", "-0.001738996", "1e-8", size, prec) {
+		orbit, want := variants["mandelbrot"].orbitHP(p[0], p[1], iterations, 0, 2)
+		for n, z := range orbit {
+			if n+1 < len(r.orbit) && cmplx.Abs(z) < cmplx.Abs(z-r.orbit[n+1]) {
+				rebased++
+				break
+			}
+		}
+		if _, got := r.orbitOf(p[0], p[1], iterations, 0, 2); got != want {
+			t.Errorf("(%s, %s) escaped at %d, want %d",
+				p[0].Text('g', 20), p[1].Text('g', 20), got, want)
+		}
+	}
+	if rebased == 0 {
+		t.Error("no pixel in the frame is rebased")
+	}
+}
+
+// Pixels that outlast the reference are followed in big.Float.
+func TestReferenceFallback(t *testing.T) {
+	const prec = 128
+
+	cR := new(big.Float).SetPrec(prec).SetFloat64(-1)
+	cI := new(big.Float).SetPrec(prec)
+	orbit, _ := variants["mandelbrot"].orbitHP(cR, cI, 10, 0, 2)
+	r := &reference{cR, cI, append([]complex128{0}, orbit...)}
+
+	got, n := r.orbitOf(cR, cI, 100, 0, 2)
+	want, wantN := variants["mandelbrot"].orbitHP(cR, cI, 100, 0, 2)
+	if n != wantN || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d points escaping at %d, want %d escaping at %d",
+			len(got), n, len(want), wantN)
+	}
+}
+
+// A reference that escapes is replaced by a longer lived point of the
+// frame.
+func TestNewReference(t *testing.T) {
+	const prec = 128
+
+	v := func(x float64) *big.Float {
+		return new(big.Float).SetPrec(prec).SetFloat64(x)
+	}
+	// 0.3 escapes; 0.1 to 0.25 is inside the set.
+	f := ConstructFrameInfoHP(
+		v(0.2), v(0.1), v(-0.2), v(0.5), v(0.2), v(0.3), v(0),
+	)
+	r := newReference(f, 500)
+	if len(r.orbit) != 501 {
+		t.Errorf("reference lasts %d iterations, want 500", len(r.orbit)-1)
+	}
+	if x, _ := r.cR.Float64(); x >= 0.25 || math.Abs(x-0.3) > 0.2 {
+		t.Errorf("reference at %g, outside the set or the frame", x)
+	}
+}
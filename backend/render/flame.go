@@ -0,0 +1,526 @@
+package render
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fractal flames: an IFS whose affine maps are followed by a weighted sum
+// of nonlinear variations. Every point also carries a colour index that
+// drifts towards the index of each transform it passes through, and the
+// final image is drawn from the log of the density.
+
+type variation func(x, y float64) (float64, float64)
+
+// r and theta follow the original flame algorithm, where theta is
+// measured from the y axis.
+var variations = map[string]variation{
+	"linear": func(x, y float64) (float64, float64) {
+		return x, y
+	},
+	"sinusoidal": func(x, y float64) (float64, float64) {
+		return math.Sin(x), math.Sin(y)
+	},
+	"spherical": func(x, y float64) (float64, float64) {
+		r2 := x*x + y*y + 1e-10
+		return x / r2, y / r2
+	},
+	"swirl": func(x, y float64) (float64, float64) {
+		r2 := x*x + y*y
+		s, c := math.Sincos(r2)
+		return x*s - y*c, x*c + y*s
+	},
+	"horseshoe": func(x, y float64) (float64, float64) {
+		r := math.Hypot(x, y) + 1e-10
+		return (x - y) * (x + y) / r, 2 * x * y / r
+	},
+	"polar": func(x, y float64) (float64, float64) {
+		return math.Atan2(x, y) / math.Pi, math.Hypot(x, y) - 1
+	},
+	"handkerchief": func(x, y float64) (float64, float64) {
+		r, theta := math.Hypot(x, y), math.Atan2(x, y)
+		return r * math.Sin(theta+r), r * math.Cos(theta-r)
+	},
+	"heart": func(x, y float64) (float64, float64) {
+		r, theta := math.Hypot(x, y), math.Atan2(x, y)
+		return r * math.Sin(theta*r), -r * math.Cos(theta*r)
+	},
+	"disc": func(x, y float64) (float64, float64) {
+		r, theta := math.Hypot(x, y), math.Atan2(x, y)
+		return theta / math.Pi * math.Sin(math.Pi*r),
+			theta / math.Pi * math.Cos(math.Pi*r)
+	},
+	"spiral": func(x, y float64) (float64, float64) {
+		r, theta := math.Hypot(x, y)+1e-10, math.Atan2(x, y)
+		return (math.Cos(theta) + math.Sin(r)) / r,
+			(math.Sin(theta) - math.Cos(r)) / r
+	},
+	"hyperbolic": func(x, y float64) (float64, float64) {
+		r, theta := math.Hypot(x, y)+1e-10, math.Atan2(x, y)
+		return math.Sin(theta) / r, r * math.Cos(theta)
+	},
+	"diamond": func(x, y float64) (float64, float64) {
+		r, theta := math.Hypot(x, y), math.Atan2(x, y)
+		return math.Sin(theta) * math.Cos(r), math.Cos(theta) * math.Sin(r)
+	},
+	"fisheye": func(x, y float64) (float64, float64) {
+		f := 2 / (math.Hypot(x, y) + 1)
+		return f * y, f * x
+	},
+	"bubble": func(x, y float64) (float64, float64) {
+		f := 4 / (x*x + y*y + 4)
+		return f * x, f * y
+	},
+}
+
+// The embedded map is applied first. Its P is the weight used to choose
+// the transform.
+type FlameTransform struct {
+	AffineMap
+	// Weights of the variations to sum, by name.
+	Variations map[string]float64 `json:"variations"`
+	// Palette index in [0, 1].
+	Color float64 `json:"color"`
+}
+
+type Flame struct {
+	Transforms []FlameTransform `json:"transforms"`
+	// Hex colours such as "#ff8000", spread evenly over the colour
+	// indices. A rainbow is used when empty.
+	Palette []string `json:"palette"`
+	Gamma   float64  `json:"gamma"`
+	// 1 when absent, as in flam3. 0 is a valid setting.
+	Vibrancy   *float64 `json:"vibrancy"`
+	Brightness float64  `json:"brightness"`
+	// Each output pixel is the average of Supersample^2 bins. 1 to
+	// maxSupersample.
+	Supersample int `json:"supersample"`
+}
+
+const maxSupersample = 4
+
+// Every worker keeps the whole supersampled frame, so flames run on fewer
+// workers as it grows to keep all of their bins within this. Each bin
+// takes 32 bytes.
+const maxFlameBins = 1 << 24
+
+// Lower than MaxIFSPoints, since each point applies a whole list of
+// variations.
+const MaxFlamePoints = 100000000
+
+type flameTerm struct {
+	weight float64
+	v      variation
+}
+
+type compiledFlame struct {
+	ifs     IFS
+	terms   [][]flameTerm
+	colors  []float64
+	palette []colorful.Color
+}
+
+func (f Flame) compile() (compiledFlame, error) {
+	maps := make([]AffineMap, len(f.Transforms))
+	for i, t := range f.Transforms {
+		maps[i] = t.AffineMap
+	}
+	s, err := NewIFS(maps)
+	if err != nil {
+		return compiledFlame{}, err
+	}
+
+	c := compiledFlame{
+		ifs:    s,
+		terms:  make([][]flameTerm, len(f.Transforms)),
+		colors: make([]float64, len(f.Transforms)),
+	}
+	for i, t := range f.Transforms {
+		// Sorted so that sums, and so renders, are reproducible.
+		names := make([]string, 0, len(t.Variations))
+		for name := range t.Variations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			weight := t.Variations[name]
+			v, ok := variations[name]
+			if !ok {
+				return compiledFlame{}, fmt.Errorf(
+					"flame transform %d has unknown variation %q", i, name,
+				)
+			}
+			c.terms[i] = append(c.terms[i], flameTerm{weight, v})
+		}
+		if len(c.terms[i]) == 0 {
+			c.terms[i] = []flameTerm{{1, variations["linear"]}}
+		}
+		c.colors[i] = math.Min(math.Max(t.Color, 0), 1)
+	}
+
+	for _, hex := range f.Palette {
+		col, err := colorful.Hex(hex)
+		if err != nil {
+			return compiledFlame{}, fmt.Errorf("flame palette: %v", err)
+		}
+		c.palette = append(c.palette, col)
+	}
+	if len(c.palette) == 0 {
+		for i := 0; i < 256; i++ {
+			c.palette = append(c.palette, colorful.Hsv(float64(i)*360/256, 0.8, 1))
+		}
+	}
+	return c, nil
+}
+
+func (c compiledFlame) paletteAt(index float64) colorful.Color {
+	i := int(index * float64(len(c.palette)-1))
+	return c.palette[i]
+}
+
+// Bins of the supersampled frame, each with a hit count and the summed
+// colour of its hits.
+type flameBuffer struct {
+	*histogram
+	r, g, b []float64
+}
+
+func newFlameBuffer(width, height int, xmin, ymin, xmax, ymax float64) flameBuffer {
+	h := newHistogram(width, height, xmin, ymin, xmax, ymax)
+	return flameBuffer{
+		histogram: h,
+		r:         make([]float64, len(h.bins)),
+		g:         make([]float64, len(h.bins)),
+		b:         make([]float64, len(h.bins)),
+	}
+}
+
+func (f flameBuffer) add(o flameBuffer) {
+	f.histogram.add(o.histogram)
+	for i := range f.r {
+		f.r[i] += o.r[i]
+		f.g[i] += o.g[i]
+		f.b[i] += o.b[i]
+	}
+}
+
+// Only the Points and Seed options apply; colouring comes from the flame.
+func RenderFlame(
+	width, height int, f FrameInfo, fl Flame, o IFSOptions,
+) (<-chan image.Image, error) {
+	const maxWorkers = 4
+	const skip = 20
+
+	c, err := fl.compile()
+	if err != nil {
+		return nil, err
+	}
+	if o.Points > MaxFlamePoints {
+		return nil, fmt.Errorf(
+			"flame points %d is over the limit of %d", o.Points, MaxFlamePoints,
+		)
+	}
+	ss := fl.Supersample
+	if ss > maxSupersample {
+		return nil, fmt.Errorf(
+			"flame supersample %d is over the limit of %d", ss, maxSupersample,
+		)
+	}
+	if ss < 1 {
+		ss = 1
+	}
+	bins := width * ss * height * ss
+	if bins > maxFlameBins {
+		return nil, fmt.Errorf(
+			"flame bins %d is over the limit of %d", bins, maxFlameBins,
+		)
+	}
+	workers := maxFlameBins / bins
+	if workers > maxWorkers {
+		workers = maxWorkers
+	}
+	gamma, vibrancy, brightness := fl.Gamma, 1.0, fl.Brightness
+	if fl.Vibrancy != nil {
+		vibrancy = *fl.Vibrancy
+	}
+	if gamma <= 0 {
+		gamma = 4
+	}
+	if brightness <= 0 {
+		brightness = 1
+	}
+
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering flame (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
+	ch := make(chan image.Image)
+	go func() {
+		var wg sync.WaitGroup
+		results := make([]flameBuffer, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				points := o.Points / workers
+				if w < o.Points%workers {
+					points++
+				}
+				buf := newFlameBuffer(
+					width*ss, height*ss, xmin, ymin, xmax, ymax,
+				)
+				r := rand.New(rand.NewSource(o.Seed + int64(w)))
+				x, y := 2*r.Float64()-1, 2*r.Float64()-1
+				index := r.Float64()
+				for i := 0; i < points+skip; i++ {
+					t := c.ifs.choose(r)
+					ax, ay := c.ifs.maps[t].apply(x, y)
+					x, y = 0, 0
+					for _, term := range c.terms[t] {
+						vx, vy := term.v(ax, ay)
+						x += term.weight * vx
+						y += term.weight * vy
+					}
+					index = (index + c.colors[t]) / 2
+					if math.IsNaN(x) || math.IsNaN(y) ||
+						math.IsInf(x, 0) || math.IsInf(y, 0) {
+						x, y = 2*r.Float64()-1, 2*r.Float64()-1
+						continue
+					}
+					if i < skip {
+						continue
+					}
+					if bin, ok := buf.index(x, -y); ok {
+						col := c.paletteAt(index)
+						buf.bins[bin]++
+						buf.r[bin] += col.R
+						buf.g[bin] += col.G
+						buf.b[bin] += col.B
+					}
+				}
+				results[w] = buf
+			}(w)
+		}
+		wg.Wait()
+
+		buf := results[0]
+		for _, r := range results[1:] {
+			buf.add(r)
+		}
+		ch <- buf.image(width, height, ss, gamma, vibrancy, brightness)
+	}()
+	return ch, nil
+}
+
+// Log-density display. Vibrancy blends gamma applied to the density alone,
+// which keeps colours saturated, with gamma applied to each channel.
+func (f flameBuffer) image(
+	width, height, ss int, gamma, vibrancy, brightness float64,
+) image.Image {
+	var max float64
+	for _, v := range f.bins {
+		max = math.Max(max, v)
+	}
+	logMax := math.Log1p(max)
+	if logMax == 0 {
+		logMax = 1
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			var sum [3]float64
+			for sy := 0; sy < ss; sy++ {
+				for sx := 0; sx < ss; sx++ {
+					i := (py*ss+sy)*f.width + px*ss + sx
+					count := f.bins[i]
+					if count == 0 {
+						continue
+					}
+					alpha := math.Min(brightness*math.Log1p(count)/logMax, 1)
+					alphaG := math.Pow(alpha, 1/gamma)
+					for k, channel := range [3]float64{
+						f.r[i] / count, f.g[i] / count, f.b[i] / count,
+					} {
+						sum[k] += vibrancy*channel*alphaG +
+							(1-vibrancy)*math.Pow(channel*alpha, 1/gamma)
+					}
+				}
+			}
+			var rgb [3]uint8
+			for k := range sum {
+				v := sum[k] / float64(ss*ss)
+				rgb[k] = uint8(255 * math.Min(math.Max(v, 0), 1))
+			}
+			img.Set(px, py, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+		}
+	}
+	return img
+}
+
+// .flame XML import
+
+type xmlFlame struct {
+	Attrs   []xml.Attr  `xml:",any,attr"`
+	Xforms  []xmlAttrs  `xml:"xform"`
+	Colors  []xmlAttrs  `xml:"color"`
+	Palette *xmlPalette `xml:"palette"`
+}
+
+type xmlAttrs struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type xmlPalette struct {
+	Count int    `xml:"count,attr"`
+	Data  string `xml:",chardata"`
+}
+
+// Reads the first <flame> element, which may be nested in <flames>.
+// Camera attributes such as center and scale are ignored since the frame
+// comes from the request, as are variations this package does not know.
+func ParseFlameXML(r io.Reader) (Flame, error) {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return Flame{}, fmt.Errorf("no <flame> element found")
+		}
+		if err != nil {
+			return Flame{}, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "flame" {
+			continue
+		}
+		var x xmlFlame
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return Flame{}, err
+		}
+		return x.flame()
+	}
+}
+
+func parseFloats(s string) ([]float64, error) {
+	fields := strings.Fields(s)
+	ret := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+func (x xmlFlame) flame() (Flame, error) {
+	var f Flame
+	for _, a := range x.Attrs {
+		v, err := strconv.ParseFloat(a.Value, 64)
+		switch a.Name.Local {
+		case "gamma":
+			f.Gamma = v
+		case "vibrancy":
+			f.Vibrancy = &v
+		case "brightness":
+			f.Brightness = v
+		case "supersample":
+			f.Supersample = int(v)
+		default:
+			continue
+		}
+		if err != nil {
+			return Flame{}, fmt.Errorf("flame attribute %s: %v", a.Name.Local, err)
+		}
+	}
+
+	for i, xf := range x.Xforms {
+		t := FlameTransform{Variations: map[string]float64{}}
+		for _, a := range xf.Attrs {
+			name := a.Name.Local
+			if name == "coefs" {
+				// Listed as xx yx xy yy ox oy.
+				c, err := parseFloats(a.Value)
+				if err != nil || len(c) != 6 {
+					return Flame{}, fmt.Errorf("xform %d has invalid coefs %q", i, a.Value)
+				}
+				t.A, t.C, t.B, t.D, t.E, t.F = c[0], c[1], c[2], c[3], c[4], c[5]
+				continue
+			}
+			if name != "weight" && name != "color" && variations[name] == nil {
+				continue
+			}
+			fields := strings.Fields(a.Value)
+			if len(fields) == 0 {
+				return Flame{}, fmt.Errorf("xform %d has empty %s", i, name)
+			}
+			v, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return Flame{}, fmt.Errorf("xform %d %s: %v", i, name, err)
+			}
+			switch name {
+			case "weight":
+				t.P = v
+			case "color":
+				t.Color = v
+			default:
+				t.Variations[name] = v
+			}
+		}
+		f.Transforms = append(f.Transforms, t)
+	}
+
+	if len(x.Colors) > 0 {
+		palette := make([]string, 256)
+		for _, c := range x.Colors {
+			index, rgb := -1, []float64(nil)
+			for _, a := range c.Attrs {
+				switch a.Name.Local {
+				case "index":
+					n, err := strconv.Atoi(a.Value)
+					if err != nil {
+						return Flame{}, fmt.Errorf("color index: %v", err)
+					}
+					index = n
+				case "rgb":
+					v, err := parseFloats(a.Value)
+					if err != nil || len(v) != 3 {
+						return Flame{}, fmt.Errorf("invalid color rgb %q", a.Value)
+					}
+					rgb = v
+				}
+			}
+			if index < 0 || index > 255 || rgb == nil {
+				continue
+			}
+			palette[index] = fmt.Sprintf(
+				"#%02x%02x%02x", uint8(rgb[0]), uint8(rgb[1]), uint8(rgb[2]),
+			)
+		}
+		// Entries the file skipped repeat the previous colour.
+		last := "#000000"
+		for i, p := range palette {
+			if p == "" {
+				palette[i] = last
+			}
+			last = palette[i]
+		}
+		f.Palette = palette
+	} else if x.Palette != nil {
+		hex := strings.Join(strings.Fields(x.Palette.Data), "")
+		for i := 0; i+6 <= len(hex); i += 6 {
+			f.Palette = append(f.Palette, "#"+hex[i:i+6])
+		}
+	}
+	return f, nil
+}
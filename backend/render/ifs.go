@@ -0,0 +1,199 @@
+package render
+
+import (
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Iterated function systems, drawn with the chaos game: a point is moved
+// by randomly chosen affine maps and every position it visits is counted.
+
+// x' = A*x + B*y + E
+// y' = C*x + D*y + F
+// P is the probability of choosing the map.
+type AffineMap struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+	C float64 `json:"c"`
+	D float64 `json:"d"`
+	E float64 `json:"e"`
+	F float64 `json:"f"`
+	P float64 `json:"p"`
+}
+
+func (m AffineMap) apply(x, y float64) (float64, float64) {
+	return m.A*x + m.B*y + m.E, m.C*x + m.D*y + m.F
+}
+
+var IFSPresets = map[string][]AffineMap{
+	// Fits in x in [-2.2, 2.7], y in [0, 10].
+	"barnsleyFern": {
+		{A: 0, B: 0, C: 0, D: 0.16, E: 0, F: 0, P: 0.01},
+		{A: 0.85, B: 0.04, C: -0.04, D: 0.85, E: 0, F: 1.6, P: 0.85},
+		{A: 0.2, B: -0.26, C: 0.23, D: 0.22, E: 0, F: 1.6, P: 0.07},
+		{A: -0.15, B: 0.28, C: 0.26, D: 0.24, E: 0, F: 0.44, P: 0.07},
+	},
+	// Fits in the unit square.
+	"sierpinski": {
+		{A: 0.5, D: 0.5, E: 0, F: 0, P: 1},
+		{A: 0.5, D: 0.5, E: 0.5, F: 0, P: 1},
+		{A: 0.5, D: 0.5, E: 0.25, F: 0.5, P: 1},
+	},
+	// Fits in x in [-0.5, 1.5], y in [-1, 0.25].
+	"levyC": {
+		{A: 0.5, B: 0.5, C: -0.5, D: 0.5, E: 0, F: 0, P: 1},
+		{A: 0.5, B: -0.5, C: 0.5, D: 0.5, E: 0.5, F: -0.5, P: 1},
+	},
+}
+
+type IFS struct {
+	maps []AffineMap
+	// Running totals of the normalised probabilities.
+	cumulative []float64
+}
+
+// When every probability is 0, maps are weighted by how much area they
+// cover, the usual choice for an even density.
+func NewIFS(maps []AffineMap) (IFS, error) {
+	if len(maps) == 0 {
+		return IFS{}, fmt.Errorf("ifs has no maps")
+	}
+
+	weights := make([]float64, len(maps))
+	var total float64
+	for i, m := range maps {
+		if m.P < 0 || math.IsNaN(m.P) || math.IsInf(m.P, 0) {
+			return IFS{}, fmt.Errorf("ifs map %d has invalid probability %g", i, m.P)
+		}
+		weights[i] = m.P
+		total += m.P
+	}
+	if total == 0 {
+		for i, m := range maps {
+			weights[i] = math.Max(math.Abs(m.A*m.D-m.B*m.C), 0.01)
+			total += weights[i]
+		}
+	}
+
+	s := IFS{maps: maps, cumulative: make([]float64, len(maps))}
+	var sum float64
+	for i, w := range weights {
+		sum += w / total
+		s.cumulative[i] = sum
+	}
+	s.cumulative[len(maps)-1] = 1
+	return s, nil
+}
+
+// Returns the index of a randomly chosen map.
+func (s IFS) choose(r *rand.Rand) int {
+	u := r.Float64()
+	for i, c := range s.cumulative {
+		if u < c {
+			return i
+		}
+	}
+	return len(s.maps) - 1
+}
+
+type IFSOptions struct {
+	// Number of chaos game iterations.
+	Points int
+	// Seeds the chaos game, so that a render can be repeated exactly.
+	Seed int64
+	// Map log(1 + count) to brightness so sparse regions stay visible.
+	LogDensity bool
+	Colorized  bool
+}
+
+const MaxIFSPoints = 200000000
+
+// Maps a density in [0, 1] to a colour.
+func densityColor(v float64, colorized bool) color.Color {
+	if colorized {
+		return colorful.Hsv(240-180*v, 0.7, v)
+	}
+	return color.Gray{uint8(255 * v)}
+}
+
+// Positive y is drawn upwards, matching the flipped y axis of the frames
+// built by the server.
+func RenderIFS(
+	width, height int, f FrameInfo, s IFS, o IFSOptions,
+) (<-chan image.Image, error) {
+	const workers = 4
+	// Iterations before the point has settled onto the attractor.
+	const skip = 20
+
+	if o.Points > MaxIFSPoints {
+		return nil, fmt.Errorf(
+			"ifs points %d is over the limit of %d", o.Points, MaxIFSPoints,
+		)
+	}
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering ifs (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
+	c := make(chan image.Image)
+	go func() {
+		var wg sync.WaitGroup
+		results := make([]*histogram, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				points := o.Points / workers
+				if w < o.Points%workers {
+					points++
+				}
+				h := newHistogram(width, height, xmin, ymin, xmax, ymax)
+				r := rand.New(rand.NewSource(o.Seed + int64(w)))
+				var x, y float64
+				for i := 0; i < points+skip; i++ {
+					x, y = s.maps[s.choose(r)].apply(x, y)
+					if i >= skip {
+						h.plot(x, -y, 1)
+					}
+				}
+				results[w] = h
+			}(w)
+		}
+		wg.Wait()
+
+		h := results[0]
+		for _, r := range results[1:] {
+			h.add(r)
+		}
+		c <- toneMap(h, o.LogDensity, o.Colorized)
+	}()
+	return c, nil
+}
+
+func toneMap(h *histogram, logDensity, colorized bool) image.Image {
+	var max float64
+	for _, v := range h.bins {
+		max = math.Max(max, v)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, h.width, h.height))
+	for py := 0; py < h.height; py++ {
+		for px := 0; px < h.width; px++ {
+			count := h.bins[py*h.width+px]
+			if count == 0 {
+				img.Set(px, py, color.Black)
+				continue
+			}
+			var v float64
+			if logDensity {
+				v = math.Log1p(count) / math.Log1p(max)
+			} else {
+				v = count / max
+			}
+			img.Set(px, py, densityColor(v, colorized))
+		}
+	}
+	return img
+}
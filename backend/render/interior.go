@@ -0,0 +1,245 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Interior colouring for the standard Mandelbrot set. Points that do not
+// escape are coloured by what their orbit settles into instead of black.
+// The exterior keeps the usual escape count colouring.
+
+// How many iterations an interior orbit is given to settle onto its
+// cycle before the period is measured.
+const settleIterations = 1000
+
+// The longest cycle looked for, and how close an orbit has to come back
+// to a point to have cycled.
+const (
+	maxPeriod       = 64
+	periodTolerance = 1e-9
+)
+
+func cycled(z, w complex128) bool {
+	return cmplx.Abs(z-w) < periodTolerance*math.Max(1, cmplx.Abs(w))
+}
+
+// Follows z under step onto the cycle it settles into. Returns the period
+// of the cycle and a point on it, or 0 if the orbit has not settled, which
+// happens close to the boundary.
+func cyclePeriod(
+	z, c complex128, step func(z, c complex128) complex128,
+) (int, complex128) {
+	for i := 0; i < settleIterations; i++ {
+		z = step(z, c)
+	}
+	w := z
+	for i := 1; i <= maxPeriod; i++ {
+		z = step(z, c)
+		if cycled(z, w) {
+			return i, w
+		}
+	}
+	return 0, w
+}
+
+// Like cyclePeriod for an orbit already followed settleIterations +
+// maxPeriod steps, at whatever precision its c needs. Rounding the points
+// of the orbit to float64 does not matter to the period, but rounding c
+// does at deep zooms.
+func orbitPeriod(orbit []complex128) (int, complex128) {
+	if len(orbit) < settleIterations+maxPeriod {
+		return 0, 0
+	}
+	tail := orbit[len(orbit)-maxPeriod-1:]
+	w := tail[0]
+	for i := 1; i <= maxPeriod; i++ {
+		if cycled(tail[i], w) {
+			return i, w
+		}
+	}
+	return 0, w
+}
+
+// Like cyclePeriod for the orbit of c in the Mandelbrot set, with the
+// point refined with Newton's method.
+func period(c complex128) (int, complex128) {
+	p, w := cyclePeriod(0, c, variants["mandelbrot"].step)
+	if p == 0 {
+		return 0, w
+	}
+	// Solve F^p(w) = w, where F is the iteration.
+	for i := 0; i < 16; i++ {
+		z, dz := w, complex(1, 0)
+		for j := 0; j < p; j++ {
+			dz = 2 * z * dz
+			z = z*z + c
+		}
+		if dz == 1 {
+			break
+		}
+		step := (z - w) / (dz - 1)
+		w -= step
+		if cmplx.Abs(step) < 1e-15 {
+			break
+		}
+	}
+	return p, w
+}
+
+// Estimates the distance from c to the boundary, given a point w on its
+// cycle of period p.
+func interiorDistance(c, w complex128, p int) float64 {
+	cycle := make([]complex128, p)
+	cycle[0] = w
+	for i := 1; i < p; i++ {
+		cycle[i] = cycle[i-1]*cycle[i-1] + c
+	}
+	return cycleDistance(cycle)
+}
+
+// Like interiorDistance, given the points of the cycle in order. c only
+// enters through them, so they can come from an orbit followed at any
+// precision.
+func cycleDistance(cycle []complex128) float64 {
+	dz, dc := complex(1, 0), complex(0, 0)
+	var dzdz, dzdc complex128
+	for _, z := range cycle {
+		dzdc = 2 * (dz*dc + z*dzdc)
+		dzdz = 2 * (dz*dz + z*dzdz)
+		dc = 2*z*dc + 1
+		dz = 2 * z * dz
+	}
+	r := cmplx.Abs(dz)
+	return (1 - r*r) / cmplx.Abs(dzdc+dzdz*dc/(1-dz))
+}
+
+// Iterations the modulus and atom colourings look at.
+const interiorIterations = 100
+
+// Returns the final value of the orbit and its atom domain: the
+// iteration at which |z| was smallest.
+func interiorOrbit(c complex128) (complex128, int) {
+	var z complex128
+	atom, closest := 0, math.Inf(1)
+	for n := 1; n <= interiorIterations; n++ {
+		z = z*z + c
+		if r := cmplx.Abs(z); r < closest {
+			atom, closest = n, r
+		}
+	}
+	return z, atom
+}
+
+// Like interiorOrbit for an orbit already followed, starting after z = 0.
+func atomOf(orbit []complex128) (complex128, int) {
+	orbit = orbit[:interiorIterations]
+	atom, closest := 0, math.Inf(1)
+	for n, z := range orbit {
+		if r := cmplx.Abs(z); r < closest {
+			atom, closest = n+1, r
+		}
+	}
+	return orbit[len(orbit)-1], atom
+}
+
+// Spreads small integers around the colour wheel by the golden angle so
+// that neighbouring values stand apart.
+func indexColor(i int, colorized bool) color.Color {
+	const golden = 137.50776
+	if colorized {
+		return colorful.Hsv(math.Mod(golden*float64(i), 360), 0.6, 0.9)
+	}
+	return color.Gray{uint8(60 + (i*47)%196)}
+}
+
+func modulusColor(z complex128, colorized bool) color.Color {
+	v := math.Min(cmplx.Abs(z)/2, 1)
+	if colorized {
+		return colorful.Hsv(200+100*v, 0.7, 0.3+0.7*v)
+	}
+	return color.Gray{uint8(255 * v)}
+}
+
+// Returns the colour of c under interior, one of "modulus", "period",
+// "distance" or "atom". Anything else gives black, as before.
+func interiorColor(
+	interior string, c complex128, pixelSize float64, colorized bool,
+) color.Color {
+	switch interior {
+	case "modulus":
+		z, _ := interiorOrbit(c)
+		return modulusColor(z, colorized)
+	case "period":
+		p, _ := period(c)
+		if p == 0 {
+			return color.Black
+		}
+		return indexColor(p, colorized)
+	case "distance":
+		p, w := period(c)
+		if p == 0 {
+			return color.Black
+		}
+		return distanceColor(interiorDistance(c, w, p), pixelSize, 1, colorized)
+	case "atom":
+		_, atom := interiorOrbit(c)
+		return indexColor(atom, colorized)
+	}
+	return color.Black
+}
+
+// Like the standard Mandelbrot MandelFunc, with the interior coloured by
+// interior. pixelSize is the width of a pixel, used by "distance".
+func GetInteriorFunc(
+	interior string, pixelSize float64, colorized bool,
+) MandelFunc {
+	v := variants["mandelbrot"]
+	return func(c complex128) color.Color {
+		n, _, escaped := v.iterate(c)
+		if escaped {
+			return escapeColor(n, colorized)
+		}
+		return interiorColor(interior, c, pixelSize, colorized)
+	}
+}
+
+// Like GetInteriorFunc for high precision renders. Each orbit is followed
+// at high precision, far enough to find its period, and the distance
+// estimate is taken along the cycle it settles into.
+func GetInteriorFuncHP(
+	a *Arithmetic, interior string, pixelSize float64, colorized bool,
+) MandelFuncHP {
+	v := variants["mandelbrot"]
+	return func(zR, zI *big.Float) color.Color {
+		orbit, n := a.orbit(v, zR, zI, settleIterations+maxPeriod, 0, 2)
+		if n >= 0 && n < interiorIterations {
+			return escapeColor(uint8(n), colorized)
+		}
+		switch interior {
+		case "modulus":
+			z, _ := atomOf(orbit)
+			return modulusColor(z, colorized)
+		case "period":
+			p, _ := orbitPeriod(orbit)
+			if p == 0 {
+				return color.Black
+			}
+			return indexColor(p, colorized)
+		case "distance":
+			p, _ := orbitPeriod(orbit)
+			if p == 0 {
+				return color.Black
+			}
+			cycle := orbit[len(orbit)-maxPeriod-1:][:p]
+			return distanceColor(cycleDistance(cycle), pixelSize, 1, colorized)
+		case "atom":
+			_, atom := atomOf(orbit)
+			return indexColor(atom, colorized)
+		}
+		return color.Black
+	}
+}
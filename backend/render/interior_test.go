@@ -0,0 +1,46 @@
+package render
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Interior colouring looks the same whichever precision it is rendered
+// at.
+func TestInteriorFuncHPMatches(t *testing.T) {
+	const (
+		size = 24
+		prec = 128
+	)
+
+	v := func(x float64) *big.Float {
+		return new(big.Float).SetPrec(prec).SetFloat64(x)
+	}
+	f := ConstructFrameInfoHP(v(1.5), v(-2), v(-1.5), v(1), v(1.5), v(-0.5), v(0))
+	pixelSize := 2 * 1.5 / size
+	for _, tier := range []string{
+		PrecisionDoubleDouble, PrecisionExtended, PrecisionBigFloat,
+	} {
+		a := NewArithmetic(tier, f)
+		for _, interior := range []string{"modulus", "period", "distance", "atom"} {
+			want := GetInteriorFunc(interior, pixelSize, true)
+			got := GetInteriorFuncHP(a, interior, pixelSize, true)
+			diff := 0
+			for j := 0; j < size; j++ {
+				for i := 0; i < size; i++ {
+					x := -2 + 3*(float64(i)+0.5)/size
+					y := -1.5 + 3*(float64(j)+0.5)/size
+					r0, g0, b0, _ := want(complex(x, y)).RGBA()
+					r1, g1, b1, _ := got(v(x), v(y)).RGBA()
+					if r0 != r1 || g0 != g1 || b0 != b1 {
+						diff++
+					}
+				}
+			}
+			if diff > 0 {
+				t.Errorf("%s %s: %d of %d points differ",
+					tier, interior, diff, size*size)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Lighting treats the fractal as a surface and shades it with a Lambert
+// diffuse term and a Blinn-Phong highlight. Surface normals come from the
+// distance estimate derivative where there is one, or from the slope of
+// the smooth iteration count otherwise.
+
+type Light struct {
+	// Direction the light comes from in degrees, counter-clockwise from
+	// the right of the image.
+	Angle float64
+	// Height of the light above the image plane, relative to a unit step
+	// across it. Higher lights give flatter shading.
+	Height float64
+	// Fraction of the colour that is lit regardless of the normal.
+	Ambient float64
+	// Strength of the highlight.
+	Specular float64
+}
+
+type vec3 [3]float64
+
+func (v vec3) normalize() vec3 {
+	l := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if l == 0 {
+		return vec3{0, 0, 1}
+	}
+	return vec3{v[0] / l, v[1] / l, v[2] / l}
+}
+
+func (v vec3) dot(o vec3) float64 {
+	return v[0]*o[0] + v[1]*o[1] + v[2]*o[2]
+}
+
+// Vectors are in the complex plane with the imaginary axis pointing down
+// the image, as in the frames built by the server, and z towards the
+// viewer.
+func (l Light) direction() vec3 {
+	a := l.Angle * math.Pi / 180
+	return vec3{math.Cos(a), -math.Sin(a), l.Height}.normalize()
+}
+
+// Shades base as seen head-on from above a surface with the given normal.
+func (l Light) shade(base color.Color, normal vec3) color.Color {
+	const shininess = 32
+
+	// The palettes build colours outside the RGB cube. Clamp them rather
+	// than let them wrap around when converted.
+	col, ok := base.(colorful.Color)
+	if ok {
+		col = col.Clamped()
+	} else {
+		col, _ = colorful.MakeColor(base)
+	}
+	if col.R == 0 && col.G == 0 && col.B == 0 {
+		return base
+	}
+	light := l.direction()
+	diffuse := math.Max(normal.dot(light), 0)
+	half := vec3{light[0], light[1], light[2] + 1}.normalize()
+	specular := l.Specular * math.Pow(math.Max(normal.dot(half), 0), shininess)
+
+	intensity := l.Ambient + (1-l.Ambient)*diffuse
+	lit := colorful.Color{
+		R: col.R*intensity + specular,
+		G: col.G*intensity + specular,
+		B: col.B*intensity + specular,
+	}
+	return lit.Clamped()
+}
+
+// Lights an orbit of z = z^d + c that escaped past radius, coloured by
+// its smooth escape count. The normal leans the way the orbit is pushed
+// outwards, z/dz.
+func litDistanceColor(
+	o deOrbit, radius float64, d complex128, l Light, colorized bool,
+) color.Color {
+	if !o.escaped {
+		return color.Black
+	}
+	base := smoothColor(smoothIteration(o.n, o.z, radius, d), colorized)
+	u := o.z / o.dz
+	u /= complex(cmplx.Abs(u), 0)
+	return l.shade(base, vec3{real(u), imag(u), 1}.normalize())
+}
+
+// Lights distance estimated renders of z = z^d + c.
+func GetLitDistanceFunc(
+	d complex128, julia bool, k complex128, l Light, colorized bool,
+) MandelFunc {
+	iterate := deIterator(d, julia)
+	radius := math.Max(escapeRadius(d), 1000)
+	if julia {
+		return func(z complex128) color.Color {
+			return litDistanceColor(iterate(z, k), radius, d, l, colorized)
+		}
+	}
+	return func(c complex128) color.Color {
+		return litDistanceColor(iterate(0, c), radius, d, l, colorized)
+	}
+}
+
+// Like GetLitDistanceFunc for the standard Mandelbrot set at high
+// precision.
+func GetLitDistanceFuncHP(
+	a *Arithmetic, l Light, colorized bool,
+) MandelFuncHP {
+	return func(cR, cI *big.Float) color.Color {
+		o := deIteratorHP(a, cR, cI)
+		return litDistanceColor(o, 1000, 2, l, colorized)
+	}
+}
+
+// Lights any render with a smooth value, sampling v one pixel across and
+// one pixel down to find the slope. base gives the unlit colour. Both
+// MandelFunc and NewtonFunc fit base and the result.
+func GetLitFunc(
+	v ValueFunc,
+	base func(complex128) color.Color,
+	l Light,
+	pixelSize float64,
+) func(complex128) color.Color {
+	return func(z complex128) color.Color {
+		h := v(z)
+		dx := v(z+complex(pixelSize, 0)) - h
+		dy := v(z+complex(0, pixelSize)) - h
+		return l.shade(base(z), vec3{-dx, -dy, 1}.normalize())
+	}
+}
+
+// Like GetLitFunc for high precision renders. The neighbouring samples are
+// placed at high precision, so the slope survives deep zooms.
+func GetLitFuncHP(
+	v ValueFuncHP,
+	base MandelFuncHP,
+	l Light,
+	pixelSize *big.Float,
+) MandelFuncHP {
+	return func(x, y *big.Float) color.Color {
+		h := v(x, y)
+		dx := v(new(big.Float).Add(x, pixelSize), y) - h
+		dy := v(x, new(big.Float).Add(y, pixelSize)) - h
+		return l.shade(base(x, y), vec3{-dx, -dy, 1}.normalize())
+	}
+}
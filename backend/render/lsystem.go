@@ -0,0 +1,352 @@
+package render
+
+import (
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"strings"
+)
+
+// L-systems: an axiom is rewritten Depth times by the production rules,
+// then read by a turtle that draws line segments.
+//
+// Turtle commands:
+//   symbols in Draw  move forward one step, drawing a line
+//   f                move forward one step without drawing
+//   + and -          turn left and right by Angle
+//   |                turn around
+//   [ and ]          push and pop the turtle's position and heading
+// Every other symbol is ignored by the turtle.
+
+type LSystem struct {
+	Axiom string `json:"axiom"`
+	// Keys are single symbols.
+	Rules map[string]string `json:"rules"`
+	// Up to MaxLSystemDepth.
+	Depth int `json:"depth"`
+	// Angles are in degrees. The turtle starts facing StartAngle, where 0
+	// is the positive x axis.
+	Angle      float64 `json:"angle"`
+	StartAngle float64 `json:"startAngle"`
+	// Length of one step. When 0 the drawing is scaled to fit the default
+	// view of the frame instead.
+	Step float64 `json:"step"`
+	// Symbols that draw. Defaults to "FG".
+	Draw string `json:"draw"`
+}
+
+var LSystemPresets = map[string]LSystem{
+	"koch": {
+		Axiom: "F--F--F",
+		Rules: map[string]string{"F": "F+F--F+F"},
+		Depth: 5,
+		Angle: 60,
+	},
+	"dragon": {
+		Axiom: "FX",
+		Rules: map[string]string{"X": "X+YF+", "Y": "-FX-Y"},
+		Depth: 14,
+		Angle: 90,
+	},
+	"hilbert": {
+		Axiom: "A",
+		Rules: map[string]string{"A": "+BF-AFA-FB+", "B": "-AF+BFB-FA-"},
+		Depth: 6,
+		Angle: 90,
+	},
+	"sierpinskiArrowhead": {
+		Axiom: "A",
+		Rules: map[string]string{"A": "B-A-B", "B": "A+B+A"},
+		Depth: 8,
+		Angle: 60,
+		Draw:  "AB",
+	},
+	"plant": {
+		Axiom:      "X",
+		Rules:      map[string]string{"X": "F+[[X]-X]-F[-FX]+X", "F": "FF"},
+		Depth:      6,
+		Angle:      25,
+		StartAngle: 90,
+	},
+}
+
+// Rules that do not grow the string never reach the length limit, so
+// the depth has one of its own.
+const MaxLSystemDepth = 32
+
+type Segment struct {
+	X0, Y0, X1, Y1 float64
+}
+
+func (l LSystem) expand() (string, error) {
+	const maxLength = 5000000
+
+	if l.Depth > MaxLSystemDepth {
+		return "", fmt.Errorf(
+			"l-system depth %d is over the limit of %d", l.Depth, MaxLSystemDepth,
+		)
+	}
+	rules := make(map[rune]string, len(l.Rules))
+	for k, v := range l.Rules {
+		symbols := []rune(k)
+		if len(symbols) != 1 {
+			return "", fmt.Errorf("l-system rule %q must rewrite a single symbol", k)
+		}
+		rules[symbols[0]] = v
+	}
+
+	s := l.Axiom
+	for i := 0; i < l.Depth; i++ {
+		var b strings.Builder
+		for _, r := range s {
+			if v, ok := rules[r]; ok {
+				b.WriteString(v)
+			} else {
+				b.WriteRune(r)
+			}
+			if b.Len() > maxLength {
+				return "", fmt.Errorf(
+					"l-system grows past %d symbols by depth %d", maxLength, i+1,
+				)
+			}
+		}
+		s = b.String()
+	}
+	return s, nil
+}
+
+// Returns the segments drawn by the turtle, in drawing order.
+func (l LSystem) Segments() ([]Segment, error) {
+	if l.Axiom == "" {
+		return nil, fmt.Errorf("l-system has no axiom")
+	}
+	s, err := l.expand()
+	if err != nil {
+		return nil, err
+	}
+	draw := l.Draw
+	if draw == "" {
+		draw = "FG"
+	}
+	step := l.Step
+	if step == 0 {
+		step = 1
+	}
+
+	type turtle struct {
+		x, y, heading float64
+	}
+	turn := l.Angle * math.Pi / 180
+	t := turtle{heading: l.StartAngle * math.Pi / 180}
+	var stack []turtle
+	var segments []Segment
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune(draw, r):
+			x, y := t.x+step*math.Cos(t.heading), t.y+step*math.Sin(t.heading)
+			segments = append(segments, Segment{t.x, t.y, x, y})
+			t.x, t.y = x, y
+		case r == 'f':
+			t.x += step * math.Cos(t.heading)
+			t.y += step * math.Sin(t.heading)
+		case r == '+':
+			t.heading += turn
+		case r == '-':
+			t.heading -= turn
+		case r == '|':
+			t.heading += math.Pi
+		case r == '[':
+			stack = append(stack, t)
+		case r == ']':
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("l-system pops an empty stack")
+			}
+			t = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if l.Step == 0 {
+		fitSegments(segments)
+	}
+	return segments, nil
+}
+
+// Scales and centres segments in place to fill the square from -1.8 to
+// 1.8, just inside the default view of a frame.
+func fitSegments(segments []Segment) {
+	const extent = 1.8
+
+	if len(segments) == 0 {
+		return
+	}
+	xmin, ymin := math.Inf(1), math.Inf(1)
+	xmax, ymax := math.Inf(-1), math.Inf(-1)
+	for _, s := range segments {
+		xmin = math.Min(xmin, math.Min(s.X0, s.X1))
+		xmax = math.Max(xmax, math.Max(s.X0, s.X1))
+		ymin = math.Min(ymin, math.Min(s.Y0, s.Y1))
+		ymax = math.Max(ymax, math.Max(s.Y0, s.Y1))
+	}
+	size := math.Max(xmax-xmin, ymax-ymin)
+	if size == 0 {
+		return
+	}
+	scale := 2 * extent / size
+	mx, my := (xmin+xmax)/2, (ymin+ymax)/2
+	for i, s := range segments {
+		segments[i] = Segment{
+			(s.X0 - mx) * scale, (s.Y0 - my) * scale,
+			(s.X1 - mx) * scale, (s.Y1 - my) * scale,
+		}
+	}
+}
+
+// Line colour composited by coverage over a black background.
+type lineCanvas struct {
+	width, height int
+	rgb           []colorful.Color
+}
+
+func (c lineCanvas) blend(px, py int, col colorful.Color, coverage float64) {
+	if px < 0 || py < 0 || px >= c.width || py >= c.height || coverage <= 0 {
+		return
+	}
+	i := py*c.width + px
+	c.rgb[i] = c.rgb[i].BlendRgb(col, math.Min(coverage, 1))
+}
+
+// Clips the segment from (x0, y0) to (x1, y1) to the box from (xmin,
+// ymin) to (xmax, ymax) with the Liang–Barsky algorithm. Returns false if
+// none of it is inside.
+func clipSegment(
+	x0, y0, x1, y1, xmin, ymin, xmax, ymax float64,
+) (float64, float64, float64, float64, bool) {
+	for _, v := range []float64{x0, y0, x1, y1} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, 0, 0, 0, false
+		}
+	}
+	dx, dy := x1-x0, y1-y0
+	t0, t1 := 0.0, 1.0
+	// The segment is inside edge i where p*t <= q.
+	for _, e := range [4][2]float64{
+		{-dx, x0 - xmin}, {dx, xmax - x0}, {-dy, y0 - ymin}, {dy, ymax - y0},
+	} {
+		p, q := e[0], e[1]
+		switch {
+		case p == 0 && q < 0:
+			return 0, 0, 0, 0, false
+		case p < 0:
+			t0 = math.Max(t0, q/p)
+		case p > 0:
+			t1 = math.Min(t1, q/p)
+		}
+	}
+	if t0 > t1 {
+		return 0, 0, 0, 0, false
+	}
+	return x0 + t0*dx, y0 + t0*dy, x0 + t1*dx, y0 + t1*dy, true
+}
+
+// Xiaolin Wu's line algorithm, in pixel coordinates. Segments are clipped
+// to a little past the canvas first, so that ones running far off it cost
+// no more than the pixels they cover.
+func (c lineCanvas) line(x0, y0, x1, y1 float64, col colorful.Color) {
+	const margin = 2
+	x0, y0, x1, y1, ok := clipSegment(
+		x0, y0, x1, y1,
+		-margin, -margin, float64(c.width+margin), float64(c.height+margin),
+	)
+	if !ok {
+		return
+	}
+	steep := math.Abs(y1-y0) > math.Abs(x1-x0)
+	if steep {
+		x0, y0, x1, y1 = y0, x0, y1, x1
+	}
+	if x0 > x1 {
+		x0, y0, x1, y1 = x1, y1, x0, y0
+	}
+	plot := func(x, y int, coverage float64) {
+		if steep {
+			c.blend(y, x, col, coverage)
+		} else {
+			c.blend(x, y, col, coverage)
+		}
+	}
+
+	dx, dy := x1-x0, y1-y0
+	gradient := 1.0
+	if dx != 0 {
+		gradient = dy / dx
+	}
+
+	// Both endpoints are weighted by how much of their pixel they cover.
+	endpoint := func(x, y float64, first bool) (int, float64) {
+		xend := math.Round(x)
+		yend := y + gradient*(xend-x)
+		xgap := x + 0.5 - math.Floor(x+0.5)
+		if first {
+			xgap = 1 - xgap
+		}
+		px, py := int(xend), int(math.Floor(yend))
+		frac := yend - math.Floor(yend)
+		plot(px, py, (1-frac)*xgap)
+		plot(px, py+1, frac*xgap)
+		return px, yend + gradient
+	}
+	xpx1, intery := endpoint(x0, y0, true)
+	xpx2, _ := endpoint(x1, y1, false)
+
+	for x := xpx1 + 1; x < xpx2; x++ {
+		frac := intery - math.Floor(intery)
+		plot(x, int(math.Floor(intery)), 1-frac)
+		plot(x, int(math.Floor(intery))+1, frac)
+		intery += gradient
+	}
+}
+
+// Drawn the same way up as RenderIFS. Colourised drawings shade the
+// segments along a gradient in drawing order.
+func RenderSegments(
+	width, height int, f FrameInfo, segments []Segment, colorized bool,
+) <-chan image.Image {
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering segments (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
+	c := make(chan image.Image)
+	go func() {
+		canvas := lineCanvas{
+			width:  width,
+			height: height,
+			rgb:    make([]colorful.Color, width*height),
+		}
+		xscale := float64(width) / (xmax - xmin)
+		yscale := float64(height) / (ymax - ymin)
+		white := colorful.Color{R: 1, G: 1, B: 1}
+		for i, s := range segments {
+			col := white
+			if colorized {
+				col = colorful.Hsv(300*float64(i)/float64(len(segments)), 0.8, 1)
+			}
+			canvas.line(
+				(s.X0-xmin)*xscale-0.5, (-s.Y0-ymin)*yscale-0.5,
+				(s.X1-xmin)*xscale-0.5, (-s.Y1-ymin)*yscale-0.5,
+				col,
+			)
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for py := 0; py < height; py++ {
+			for px := 0; px < width; px++ {
+				r, g, b := canvas.rgb[py*width+px].Clamped().RGB255()
+				img.Set(px, py, color.RGBA{r, g, b, 255})
+			}
+		}
+		c <- img
+	}()
+	return c
+}
@@ -0,0 +1,93 @@
+package render
+
+import (
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+)
+
+// Lyapunov fractals: the logistic map x = r*x*(1 - x), with r switching
+// between a and b according to a sequence such as "AABAB". The colour of
+// (a, b) comes from the Lyapunov exponent of the resulting orbit.
+
+func parseSequence(sequence string) ([]bool, error) {
+	if len(sequence) == 0 {
+		return nil, fmt.Errorf("lyapunov sequence is empty")
+	}
+	ret := make([]bool, len(sequence))
+	for i, r := range sequence {
+		switch r {
+		case 'A', 'a':
+			ret[i] = false
+		case 'B', 'b':
+			ret[i] = true
+		default:
+			return nil, fmt.Errorf(
+				"lyapunov sequence %q may only contain A and B", sequence,
+			)
+		}
+	}
+	return ret, nil
+}
+
+// Returns NaN when the orbit leaves [0, 1] and the exponent is undefined.
+func lyapunovExponent(a, b float64, useB []bool) float64 {
+	const warmup = 100
+	const iterations = 400
+
+	x := 0.5
+	r := func(n int) float64 {
+		if useB[n%len(useB)] {
+			return b
+		}
+		return a
+	}
+	for n := 0; n < warmup; n++ {
+		x = r(n) * x * (1 - x)
+	}
+
+	var sum float64
+	for n := 0; n < iterations; n++ {
+		rn := r(warmup + n)
+		x = rn * x * (1 - x)
+		if x < 0 || x > 1 || math.IsNaN(x) {
+			return math.NaN()
+		}
+		sum += math.Log(math.Abs(rn*(1-2*x)) + 1e-12)
+	}
+	return sum / iterations
+}
+
+// Stable (negative) exponents are drawn gold, chaotic (positive) ones
+// blue. Both fade to black as the exponent approaches 0.
+func lyapunovColor(lambda float64, colorized bool) color.Color {
+	if math.IsNaN(lambda) {
+		return color.Black
+	}
+	if lambda <= 0 {
+		t := 1 - math.Exp(lambda)
+		if colorized {
+			return colorful.Hsv(50, 0.9, t)
+		}
+		return color.Gray{uint8(255 * t)}
+	}
+	t := 1 - math.Exp(-lambda)
+	if colorized {
+		return colorful.Hsv(220, 0.8, t)
+	}
+	return color.Gray{uint8(80 * t)}
+}
+
+// The real part of each pixel is a and the imaginary part is -b, matching
+// the flipped y axis of the frames built by the server.
+func GetLyapunovFunc(sequence string, colorized bool) (MandelFunc, error) {
+	useB, err := parseSequence(sequence)
+	if err != nil {
+		return nil, err
+	}
+	return func(z complex128) color.Color {
+		lambda := lyapunovExponent(real(z), -imag(z), useB)
+		return lyapunovColor(lambda, colorized)
+	}, nil
+}
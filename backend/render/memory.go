@@ -0,0 +1,71 @@
+package render
+
+import (
+	"image/color"
+	"math/cmplx"
+)
+
+// Iterations whose next value depends on earlier orbit values as well as
+// the current one.
+
+// prev holds the earlier orbit values, most recent first: prev[0] is
+// z_{n-1}, prev[1] is z_{n-2} and so on. Values from before the orbit
+// started are 0.
+type MemoryStep func(z complex128, prev []complex128, c complex128) complex128
+
+type MemoryIteration struct {
+	// Number of earlier orbit values Step needs.
+	Depth int
+	Step  MemoryStep
+}
+
+// z_{n+1} = z_n^2 + c + q*z_{n-1}
+// The classic Phoenix fractal is the Julia-style render with c real.
+func Phoenix(q complex128) MemoryIteration {
+	return MemoryIteration{
+		Depth: 1,
+		Step: func(z complex128, prev []complex128, c complex128) complex128 {
+			return z*z + c + q*prev[0]
+		},
+	}
+}
+
+// Returns the iteration at which the orbit starting at z escaped.
+func (m MemoryIteration) iterate(z, c complex128) (uint8, bool) {
+	const iterations = 100
+
+	prev := make([]complex128, m.Depth)
+	for n := uint8(0); n < iterations; n++ {
+		next := m.Step(z, prev, c)
+		if len(prev) > 0 {
+			copy(prev[1:], prev)
+			prev[0] = z
+		}
+		z = next
+		if cmplx.Abs(z) > 2 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// Mandelbrot-style renders start every orbit at 0 and use the pixel as c.
+// Julia-style renders start at the pixel and use k.
+func GetMemoryFunc(
+	m MemoryIteration, julia bool, k complex128, colorized bool,
+) MandelFunc {
+	shade := func(n uint8, escaped bool) color.Color {
+		if !escaped {
+			return color.Black
+		}
+		return escapeColor(n, colorized)
+	}
+	if julia {
+		return func(z complex128) color.Color {
+			return shade(m.iterate(z, k))
+		}
+	}
+	return func(c complex128) color.Color {
+		return shade(m.iterate(0, c))
+	}
+}
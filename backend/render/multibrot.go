@@ -0,0 +1,137 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"math/cmplx"
+)
+
+// Multibrot and Multijulia sets: z = z^d + c for any complex power d.
+
+// Returns the radius beyond which every orbit of z^d + c escapes, given
+// |c| <= 2. It grows without bound as |d| approaches 1, so it is clamped.
+func escapeRadius(d complex128) float64 {
+	const maxRadius = 1e6
+
+	p := cmplx.Abs(d)
+	if p <= 1 {
+		return maxRadius
+	}
+	return math.Min(math.Max(2, math.Pow(2, 1/(p-1))), maxRadius)
+}
+
+// z^n by repeated squaring.
+func intPow(z complex128, n int) complex128 {
+	if n < 0 {
+		return 1 / intPow(z, -n)
+	}
+	ret := complex(1, 0)
+	for n > 0 {
+		if n&1 == 1 {
+			ret *= z
+		}
+		z *= z
+		n >>= 1
+	}
+	return ret
+}
+
+// Returns z^d, using repeated multiplication for integer powers.
+func powFunc(d complex128) func(complex128) complex128 {
+	if imag(d) == 0 && real(d) == math.Trunc(real(d)) &&
+		math.Abs(real(d)) <= 64 {
+		n := int(real(d))
+		if n == 2 {
+			return func(z complex128) complex128 {
+				return z * z
+			}
+		}
+		return func(z complex128) complex128 {
+			return intPow(z, n)
+		}
+	}
+	return func(z complex128) complex128 {
+		if z == 0 {
+			return 0
+		}
+		return cmplx.Pow(z, d)
+	}
+}
+
+// The fractional escape count, normalised to the escape radius so that it
+// stays continuous across iteration bands for any power.
+func smoothIteration(n int, z complex128, radius float64, d complex128) float64 {
+	logD := math.Log(cmplx.Abs(d))
+	if logD <= 0 {
+		return float64(n)
+	}
+	nu := float64(n) + 1 -
+		math.Log(math.Log(cmplx.Abs(z))/math.Log(radius))/logD
+	return math.Max(nu, 0)
+}
+
+func smoothColor(nu float64, colorized bool) color.Color {
+	const contrast = 15
+	shade := math.Mod(contrast*nu, 256)
+	if colorized {
+		return colorful.Hsv(shade, 50, 100)
+	}
+	return color.Gray{255 - uint8(shade)}
+}
+
+// Returns the smooth escape count of z^d + c, or false if the orbit
+// starting at z does not escape.
+func multibrotIterator(d complex128) func(z, c complex128) (float64, bool) {
+	const iterations = 100
+
+	pow := powFunc(d)
+	radius := escapeRadius(d)
+	return func(z, c complex128) (float64, bool) {
+		for n := 0; n < iterations; n++ {
+			z = pow(z) + c
+			if cmplx.Abs(z) > radius {
+				return smoothIteration(n, z, radius, d), true
+			}
+		}
+		return iterations, false
+	}
+}
+
+// Mandelbrot-style renders start every orbit at 0 and add the pixel.
+// Julia-style renders start at the pixel and add k.
+func GetMultibrotFunc(
+	d complex128, julia bool, k complex128, colorized bool,
+) MandelFunc {
+	iterate := multibrotIterator(d)
+	shade := func(nu float64, escaped bool) color.Color {
+		if !escaped {
+			return color.Black
+		}
+		return smoothColor(nu, colorized)
+	}
+
+	if julia {
+		return func(z complex128) color.Color {
+			return shade(iterate(z, k))
+		}
+	}
+	return func(c complex128) color.Color {
+		return shade(iterate(0, c))
+	}
+}
+
+// Like GetMultibrotFunc, but returns the smooth escape count.
+func GetMultibrotValueFunc(d complex128, julia bool, k complex128) ValueFunc {
+	iterate := multibrotIterator(d)
+	if julia {
+		return func(z complex128) float64 {
+			nu, _ := iterate(z, k)
+			return nu
+		}
+	}
+	return func(c complex128) float64 {
+		nu, _ := iterate(0, c)
+		return nu
+	}
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"math"
+	"math/big"
+)
+
+// Choosing the arithmetic for escape-time renders. Deep frames need more
+// mantissa than float64 has to tell neighbouring pixels apart, but the
+// wider types cost more the wider they get, so each frame gets the
+// fastest one that is precise enough.
+
+// Precision tiers, from fastest to slowest. Every tier past float64
+// follows orbits from coordinates held in big.Float.
+const (
+	PrecisionFloat64 = "float64"
+	// Double-double orbits, for any variant.
+	PrecisionDoubleDouble = "doubledouble"
+	// Perturbation from a big.Float reference orbit, for the standard
+	// Mandelbrot set only.
+	PrecisionExtended = "extended"
+	PrecisionBigFloat = "bigfloat"
+)
+
+// Bits of mantissa float64 has.
+const Float64Bits = 53
+
+// Bits kept on top of those that tell pixels apart, for the rounding
+// error that piles up over an orbit.
+const guardBits = 10
+
+// Returns the bits of mantissa a frame centred on (cx, cy) with pixels
+// pixelSize wide needs. Orbits of interest stay within 2 of the origin, so
+// that is the smallest scale the coordinates are taken at. pixelSize may
+// be too small for a float64.
+func PrecisionBits(cx, cy float64, pixelSize *big.Float) uint {
+	if pixelSize.Sign() <= 0 || pixelSize.IsInf() {
+		return Float64Bits
+	}
+	scale := math.Max(math.Max(math.Abs(cx), math.Abs(cy)), 2)
+	mant := new(big.Float)
+	exp := pixelSize.MantExp(mant)
+	m, _ := mant.Float64()
+	bits := math.Ceil(math.Log2(scale/m)-float64(exp)) + guardBits
+	if !(bits > Float64Bits) {
+		return Float64Bits
+	}
+	return uint(bits)
+}
+
+// Iterations of the reference orbit for the extended tier, as many as any
+// colouring takes. Finding the period of an interior orbit takes the most.
+const referenceIterations = settleIterations + maxPeriod
+
+// Returns the fastest tier that holds bits of mantissa for fractalType,
+// and the precision to give big.Float values in that tier. Past
+// double-double it is rounded up to whole words, which big.Float takes
+// the time of anyway.
+func ChoosePrecision(bits uint, fractalType string) (string, uint) {
+	switch {
+	case bits <= Float64Bits:
+		return PrecisionFloat64, Float64Bits
+	case bits <= DoubleDoubleBits:
+		// Frames at exactly this precision split into double-doubles
+		// without rounding.
+		return PrecisionDoubleDouble, DoubleDoubleBits
+	}
+	prec := (bits + 63) / 64 * 64
+	if fractalType == "mandelbrot" {
+		return PrecisionExtended, prec
+	}
+	return PrecisionBigFloat, prec
+}
+
+// The arithmetic the orbits of a high precision frame are followed in.
+// The HP colourings take one, and a nil *Arithmetic follows every orbit
+// in big.Float.
+type Arithmetic struct {
+	tier string
+	ref  *reference
+}
+
+// Returns the arithmetic for tier, which ChoosePrecision gave for f. The
+// extended tier follows its reference orbit for f here.
+func NewArithmetic(tier string, f FrameInfoHP) *Arithmetic {
+	a := &Arithmetic{tier: tier}
+	if tier == PrecisionExtended {
+		a.ref = newReference(f, referenceIterations)
+	}
+	return a
+}
+
+// Follows the orbit of c under v as orbitHP does, in the arithmetic of the
+// tier. Variants that cannot be perturbed fall back to big.Float.
+func (a *Arithmetic) orbit(
+	v variant, cR, cI *big.Float, iterations, extra int, radius float64,
+) ([]complex128, int) {
+	switch {
+	case a == nil:
+	case a.tier == PrecisionDoubleDouble:
+		return v.orbitDD(cR, cI, iterations, extra, radius)
+	case a.ref != nil && v == variants["mandelbrot"]:
+		return a.ref.orbitOf(cR, cI, iterations, extra, radius)
+	}
+	return v.orbitHP(cR, cI, iterations, extra, radius)
+}
@@ -0,0 +1,149 @@
+package render
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"testing"
+)
+
+func TestChoosePrecision(t *testing.T) {
+	tests := []struct {
+		bits        uint
+		fractalType string
+		tier        string
+		prec        uint
+	}{
+		{0, "mandelbrot", PrecisionFloat64, Float64Bits},
+		{Float64Bits, "mandelbrot", PrecisionFloat64, Float64Bits},
+		{Float64Bits, "tricorn", PrecisionFloat64, Float64Bits},
+		{Float64Bits + 1, "mandelbrot", PrecisionDoubleDouble, DoubleDoubleBits},
+		{Float64Bits + 1, "tricorn", PrecisionDoubleDouble, DoubleDoubleBits},
+		{DoubleDoubleBits, "mandelbrot", PrecisionDoubleDouble, DoubleDoubleBits},
+		{DoubleDoubleBits + 1, "mandelbrot", PrecisionExtended, 128},
+		{DoubleDoubleBits + 1, "tricorn", PrecisionBigFloat, 128},
+		{128, "mandelbrot", PrecisionExtended, 128},
+		{129, "mandelbrot", PrecisionExtended, 192},
+		{129, "buffalo", PrecisionBigFloat, 192},
+		{1000, "mandelbrot", PrecisionExtended, 1024},
+	}
+	for _, test := range tests {
+		tier, prec := ChoosePrecision(test.bits, test.fractalType)
+		if tier != test.tier || prec != test.prec {
+			t.Errorf("ChoosePrecision(%d, %q) = %s, %d, want %s, %d",
+				test.bits, test.fractalType, tier, prec, test.tier, test.prec)
+		}
+	}
+}
+
+func TestPrecisionBits(t *testing.T) {
+	pow2 := func(e int) *big.Float {
+		return new(big.Float).SetMantExp(big.NewFloat(1), e)
+	}
+	tests := []struct {
+		cx, cy    float64
+		pixelSize *big.Float
+		bits      uint
+	}{
+		{0, 0, big.NewFloat(0), Float64Bits},
+		{0, 0, big.NewFloat(math.Inf(1)), Float64Bits},
+		{0, 0, big.NewFloat(-1), Float64Bits},
+		// A full view of the set.
+		{-0.5, 0, big.NewFloat(4.0 / 1024), Float64Bits},
+		// 2 / 2^-100 takes 101 bits, and the guard bits go on top.
+		{0, 0, pow2(-100), 101 + guardBits},
+		{-1.5, 0.5, pow2(-100), 101 + guardBits},
+		// Centres further out than 2 need the bits to reach them.
+		{0, -4, pow2(-100), 102 + guardBits},
+		{3, 0, pow2(-100), 102 + guardBits},
+		// Past anything a float64 holds.
+		{0, 0, pow2(-2000), 2001 + guardBits},
+	}
+	for _, test := range tests {
+		bits := PrecisionBits(test.cx, test.cy, test.pixelSize)
+		if bits != test.bits {
+			t.Errorf("PrecisionBits(%g, %g, %s) = %d, want %d",
+				test.cx, test.cy, test.pixelSize.Text('g', 6), bits, test.bits)
+		}
+	}
+}
+
+// A size by size grid of points spanning boundary either side of (cx,
+// cy), at prec bits.
+func gridHP(cx, cy, boundary string, size int, prec uint) [][2]*big.Float {
+	parse := func(s string) *big.Float {
+		v, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+		if err != nil {
+			panic(err)
+		}
+		return v
+	}
+	x0, y0, b := parse(cx), parse(cy), parse(boundary)
+	var points [][2]*big.Float
+	for j := 0; j < size; j++ {
+		for i := 0; i < size; i++ {
+			x := new(big.Float).SetPrec(prec).SetFloat64(float64(2*i-size+1) / float64(size))
+			y := new(big.Float).SetPrec(prec).SetFloat64(float64(2*j-size+1) / float64(size))
+			x.Mul(x, b).Add(x, x0)
+			y.Mul(y, b).Add(y, y0)
+			points = append(points, [2]*big.Float{x, y})
+		}
+	}
+	return points
+}
+
+// Orbits followed in each tier escape when the big.Float ones do, in
+// frames too deep for float64 to tell the points apart. The frames are
+// around the Misiurewicz point i, where escape counts vary at any depth.
+func TestArithmeticMatchesBigFloat(t *testing.T) {
+	const (
+		size       = 12
+		iterations = 1000
+		prec       = 256
+	)
+
+	tests := []struct {
+		tier     string
+		boundary string
+	}{
+		{PrecisionDoubleDouble, "1e-20"},
+		{PrecisionExtended, "1e-20"},
+		{PrecisionExtended, "1e-40"},
+		{PrecisionBigFloat, "1e-40"},
+	}
+	v := variants["mandelbrot"]
+	for _, test := range tests {
+		points := gridHP("0", "1", test.boundary, size, prec)
+		b, _ := new(big.Float).SetPrec(prec).SetString(test.boundary)
+		one := new(big.Float).SetPrec(prec).SetInt64(1)
+		f := ConstructFrameInfoHP(
+			b,
+			new(big.Float).Neg(b), new(big.Float).Sub(one, b),
+			b, new(big.Float).Add(one, b),
+			new(big.Float).SetPrec(prec), one,
+		)
+		a := NewArithmetic(test.tier, f)
+
+		counts := make(map[int]bool)
+		for _, p := range points {
+			want, wantEscape := v.orbitHP(p[0], p[1], iterations, 0, 2)
+			got, escape := a.orbit(v, p[0], p[1], iterations, 0, 2)
+			counts[wantEscape] = true
+			if escape != wantEscape {
+				t.Errorf("%s at %s: (%s, %s) escaped at %d, want %d",
+					test.tier, test.boundary,
+					p[0].Text('g', 50), p[1].Text('g', 50), escape, wantEscape)
+				continue
+			}
+			last, wantLast := got[len(got)-1], want[len(want)-1]
+			if d := cmplx.Abs(last - wantLast); d > 1e-9 {
+				t.Errorf("%s at %s: orbit ends at %v, want %v",
+					test.tier, test.boundary, last, wantLast)
+			}
+		}
+		if len(counts) < 5 {
+			t.Errorf("%s at %s: only %d escape counts in the frame",
+				test.tier, test.boundary, len(counts))
+		}
+	}
+}
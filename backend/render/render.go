@@ -7,6 +7,7 @@ import (
 	"image/draw"
 	_ "image/png"
 	"log"
+	"math"
 	"math/big"
 	"math/cmplx"
 	"sync"
@@ -80,6 +81,19 @@ func (f FrameInfoHP) Read() (
 	return f.boundary, f.xmin, f.ymin, f.xmax, f.ymax, f.centerX, f.centerY
 }
 
+// Rounds the frame to float64. The boundary keeps its precision however
+// deep the frame is, though the corners may not.
+func (f FrameInfoHP) Float64() FrameInfo {
+	v := func(x *big.Float) float64 {
+		r, _ := x.Float64()
+		return r
+	}
+	return ConstructFrameInfo(
+		v(f.boundary), v(f.xmin), v(f.ymin), v(f.xmax), v(f.ymax),
+		v(f.centerX), v(f.centerY),
+	)
+}
+
 func combine(
 	width, height int, c1, c2, c3, c4 <-chan image.Image,
 ) <-chan image.Image {
@@ -134,14 +148,30 @@ func BigPrint(num *big.Float) string {
 }
 
 type MandelFunc func(complex128) color.Color
+type MandelFuncHP func(zR, zI *big.Float) color.Color
 
-func GetMandelFunc(colorized bool) MandelFunc {
+// Unknown fractal types fall back to the standard Mandelbrot set.
+func GetMandelFunc(fractalType string, colorized bool) MandelFunc {
+	if v, ok := variants[fractalType]; ok && fractalType != "mandelbrot" {
+		return v.mandelFunc(colorized)
+	}
 	if colorized {
 		return mandelbrotColor
 	}
 	return mandelbrotMonochrome
 }
 
+// Like GetMandelFunc for high precision renders.
+func GetMandelFuncHP(
+	a *Arithmetic, fractalType string, colorized bool,
+) MandelFuncHP {
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	return v.mandelFuncHP(a, colorized)
+}
+
 func mandelbrotMonochrome(z complex128) color.Color {
 	const iterations = 100
 	const contrast = 15
@@ -170,150 +200,18 @@ func mandelbrotColor(z complex128) color.Color {
 	return color.Black
 }
 
-func mandelbrotFloat(zR, zI *big.Float) color.Color {
-	const iterations = 100
-	const contrast = 15
-
-	vR := new(big.Float)
-	vI := new(big.Float)
-	for n := uint8(0); n < iterations; n++ {
-		// v = v*v + z
-		// (r+i)^2=r^2 + 2ri + i^2
-		vR2, vI2 := new(big.Float), new(big.Float)
-		vR2.Mul(vR, vR).Sub(vR2, new(big.Float).Mul(vI, vI)).Add(vR2, zR)
-		vI2.Mul(vR, vI).Mul(vI2, big.NewFloat(2)).Add(vI2, zI)
-		vR, vI = vR2, vI2
-
-		squareSum := new(big.Float)
-		squareSum.Mul(vR, vR).Add(squareSum, new(big.Float).Mul(vI, vI))
-		if squareSum.Cmp(big.NewFloat(4)) > 0 {
-			return colorful.Hsv(float64(contrast*n), 50, 100)
-		}
-	}
-	return color.Black
-}
-
-/*
-x1, y1 | x2, y1
-x1, y2 | x2, y2
-*/
-func generateSubpixelCoords(x, y, stepSize float64) [][]float64 {
-	ret := make([][]float64, 4)
-	for i := 0; i < 4; i++ {
-		ret[i] = make([]float64, 2)
-	}
-
-	ret[0][0] = x - stepSize
-	ret[0][1] = y - stepSize
-
-	ret[1][0] = x + stepSize
-	ret[1][1] = y + stepSize
-
-	ret[2][0] = x + stepSize
-	ret[2][1] = y - stepSize
-
-	ret[3][0] = x - stepSize
-	ret[3][1] = y + stepSize
-	return ret
-}
-
-func getAverageMandelbrot(coords [][]float64, m MandelFunc) color.Color {
-	allColors := make([]color.Color, 0)
-	for i := 0; i < len(coords); i++ {
-		x, y := coords[i][0], coords[i][1]
-		z := complex(x, y)
-		allColors = append(allColors, m(z))
-	}
-
-	var rSum uint32
-	var gSum uint32
-	var bSum uint32
-	for _, col := range allColors {
-		r, g, b, _ := col.RGBA()
-		rSum += r
-		gSum += g
-		bSum += b
-	}
-
-	numberOfColors := uint32(len(allColors))
-	rAvg := uint8(rSum / numberOfColors)
-	gAvg := uint8(gSum / numberOfColors)
-	bAvg := uint8(bSum / numberOfColors)
-	return color.NRGBA{rAvg, gAvg, bAvg, 255}
-}
-
-func renderMBoundsAA(
-	width, height int,
-	xmin, ymin, xmax, ymax float64,
-	m MandelFunc,
-) <-chan image.Image {
-	log.Printf("rendering bounds (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
-	c := make(chan image.Image)
-	stepSize := (xmax - xmin) / float64(width)
-	go func() {
-		img := image.NewRGBA(image.Rect(0, 0, width, height))
-		for py := 0; py < height; py++ {
-			y := float64(py)/float64(height)*(ymax-ymin) + ymin
-			for px := 0; px < width; px++ {
-				x := float64(px)/float64(width)*(xmax-xmin) + xmin
-				subs := generateSubpixelCoords(x, y, stepSize/2)
-				// Image point (px, py) represents complex value z.
-				img.Set(px, py, getAverageMandelbrot(subs, m))
-			}
-		}
-		c <- img
-	}()
-
-	return c
-}
-
+// Renders the frame with every pixel supersampled as o describes.
 func RenderMFrameAA(
 	width, height int,
 	f FrameInfo,
 	m MandelFunc,
+	o AAOptions,
 ) <-chan image.Image {
-	boundary, xmin, ymin, _, _, cx, cy := f.Read()
-	c1 := renderMBoundsAA(
-		width/2,
-		height/2,
-		xmin,
-		ymin,
-		xmin+boundary,
-		ymin+boundary,
-		m,
-	)
-	c2 := renderMBoundsAA(
-		width/2,
-		height/2,
-		cx,
-		ymin,
-		cx+boundary,
-		ymin+boundary,
-		m,
-	)
-	c3 := renderMBoundsAA(
-		width/2,
-		height/2,
-		xmin,
-		cy,
-		xmin+boundary,
-		cy+boundary,
-		m,
-	)
-	c4 := renderMBoundsAA(
-		width/2,
-		height/2,
-		cx,
-		cy,
-		cx+boundary,
-		cy+boundary,
-		m,
-	)
-	return combine(width, height, c1, c2, c3, c4)
+	return renderFrameAA(width, height, f, m, o)
 }
 
 func renderMBoundsHP(
-	width, height int, xmin, ymin, xmax, ymax *big.Float,
+	width, height int, xmin, ymin, xmax, ymax *big.Float, m MandelFuncHP,
 ) <-chan image.Image {
 	log.Printf("rendering bounds (%s, %s), (%s, %s)\n",
 		BigPrint(xmin), BigPrint(ymin), BigPrint(xmax), BigPrint(ymax))
@@ -322,16 +220,20 @@ func renderMBoundsHP(
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
 		for py := 0; py < height; py++ {
 			//y := float64(py)/float64(height)*(ymax-ymin) + ymin
-			y := new(big.Float).Quo(big.NewFloat(float64(py)), big.NewFloat(float64(height)))
+			// At the precision of the frame, or the sums below round to
+			// float64.
+			y := new(big.Float).SetPrec(ymin.Prec())
+			y.Quo(big.NewFloat(float64(py)), big.NewFloat(float64(height)))
 			diff := new(big.Float).Sub(ymax, ymin)
 			y.Mul(y, diff).Add(y, ymin)
 			for px := 0; px < width; px++ {
 				//x := float64(px)/float64(width)*(xmax-xmin) + xmin
-				x := new(big.Float).Quo(big.NewFloat(float64(px)), big.NewFloat(float64(width)))
+				x := new(big.Float).SetPrec(xmin.Prec())
+				x.Quo(big.NewFloat(float64(px)), big.NewFloat(float64(width)))
 				diff := new(big.Float).Sub(xmax, xmin)
 				x.Mul(x, diff).Add(x, xmin)
 				// Image point (px, py) represents complex value z.
-				img.Set(px, py, mandelbrotFloat(x, y))
+				img.Set(px, py, m(x, y))
 			}
 		}
 		c <- img
@@ -342,7 +244,9 @@ func renderMBoundsHP(
 
 // M stands for mandelbrot
 // HP stands for high-precision.
-func RenderMFrameHP(width, height int, f FrameInfoHP) <-chan image.Image {
+func RenderMFrameHP(
+	width, height int, f FrameInfoHP, m MandelFuncHP,
+) <-chan image.Image {
 	boundary, xmin, ymin, _, _, cx, cy := f.Read()
 	c1 := renderMBoundsHP(
 		width/2,
@@ -351,6 +255,7 @@ func RenderMFrameHP(width, height int, f FrameInfoHP) <-chan image.Image {
 		ymin,
 		new(big.Float).Add(xmin, boundary),
 		new(big.Float).Add(ymin, boundary),
+		m,
 	)
 	c2 := renderMBoundsHP(
 		width/2,
@@ -359,6 +264,7 @@ func RenderMFrameHP(width, height int, f FrameInfoHP) <-chan image.Image {
 		ymin,
 		new(big.Float).Add(cx, boundary),
 		new(big.Float).Add(ymin, boundary),
+		m,
 	)
 	c3 := renderMBoundsHP(
 		width/2,
@@ -367,6 +273,7 @@ func RenderMFrameHP(width, height int, f FrameInfoHP) <-chan image.Image {
 		cy,
 		new(big.Float).Add(xmin, boundary),
 		new(big.Float).Add(cy, boundary),
+		m,
 	)
 	c4 := renderMBoundsHP(
 		width/2,
@@ -375,6 +282,7 @@ func RenderMFrameHP(width, height int, f FrameInfoHP) <-chan image.Image {
 		cy,
 		new(big.Float).Add(cx, boundary),
 		new(big.Float).Add(cy, boundary),
+		m,
 	)
 	return combine(width, height, c1, c2, c3, c4)
 }
@@ -450,99 +358,13 @@ func RenderMFrame(
 type validFunc func(complex128) complex128
 type NewtonFunc func(complex128) color.Color
 
-func getAverageNewton(coords [][]float64, n NewtonFunc) color.Color {
-	allColors := make([]color.Color, 0)
-	for i := 0; i < len(coords); i++ {
-		x, y := coords[i][0], coords[i][1]
-		z := complex(x, y)
-		allColors = append(allColors, n(z))
-	}
-
-	var rSum uint32
-	var gSum uint32
-	var bSum uint32
-	for _, col := range allColors {
-		r, g, b, _ := col.RGBA()
-		rSum += r
-		gSum += g
-		bSum += b
-	}
-
-	numberOfColors := uint32(len(allColors))
-	rAvg := uint8(rSum / numberOfColors)
-	gAvg := uint8(gSum / numberOfColors)
-	bAvg := uint8(bSum / numberOfColors)
-	return color.NRGBA{rAvg, gAvg, bAvg, 255}
-}
-
-func renderNBoundsAA(
-	width, height int,
-	xmin, ymin, xmax, ymax float64,
-	n NewtonFunc,
-) <-chan image.Image {
-	log.Printf("rendering bounds (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
-	c := make(chan image.Image)
-	stepSize := (xmax - xmin) / float64(width)
-	go func() {
-		img := image.NewRGBA(image.Rect(0, 0, width, height))
-		for py := 0; py < height; py++ {
-			y := float64(py)/float64(height)*(ymax-ymin) + ymin
-			for px := 0; px < width; px++ {
-				x := float64(px)/float64(width)*(xmax-xmin) + xmin
-				subs := generateSubpixelCoords(x, y, stepSize/2)
-				// Image point (px, py) represents complex value z.
-				img.Set(px, py, getAverageNewton(subs, n))
-			}
-		}
-		c <- img
-	}()
-
-	return c
-}
-
 func RenderNFrameAA(
 	width, height int,
 	f FrameInfo,
 	n NewtonFunc,
+	o AAOptions,
 ) <-chan image.Image {
-	boundary, xmin, ymin, _, _, cx, cy := f.Read()
-	c1 := renderNBoundsAA(
-		width/2,
-		height/2,
-		xmin,
-		ymin,
-		xmin+boundary,
-		ymin+boundary,
-		n,
-	)
-	c2 := renderNBoundsAA(
-		width/2,
-		height/2,
-		cx,
-		ymin,
-		cx+boundary,
-		ymin+boundary,
-		n,
-	)
-	c3 := renderNBoundsAA(
-		width/2,
-		height/2,
-		xmin,
-		cy,
-		xmin+boundary,
-		cy+boundary,
-		n,
-	)
-	c4 := renderNBoundsAA(
-		width/2,
-		height/2,
-		cx,
-		cy,
-		cx+boundary,
-		cy+boundary,
-		n,
-	)
-	return combine(width, height, c1, c2, c3, c4)
+	return renderFrameAA(width, height, f, n, o)
 }
 
 func renderNBounds(
@@ -646,38 +468,102 @@ func newtonColor(
 	return color.Black
 }
 
+// A function paired with its derivative. start is where Nova fractals
+// begin the orbit when the pixel is used as the added constant: a simple
+// root of f where one exists.
+type validPair struct {
+	f     validFunc
+	d     validFunc
+	start complex128
+}
+
 // f(z) = z^4 - 1
 // f'(z) = 4z^3
-func NewtonOne(inColor bool) NewtonFunc {
-	f := func(x complex128) complex128 {
+var pairOne = validPair{
+	f: func(x complex128) complex128 {
 		return x*x*x*x - 1
-	}
-	d := func (x complex128) complex128 {
-		return 4*x*x*x
-	}
-	a := complex(1.0, 0)
-	if inColor {
-		return func(z complex128)  color.Color {
-			return newtonColor(z, a, f, d)
-		}
-	}
-	return func(z complex128) color.Color {
-		return newtonMonochrome(z, a, f, d)
-	}
+	},
+	d: func(x complex128) complex128 {
+		return 4 * x * x * x
+	},
+	start: 1,
 }
 
 // f(z) = z^3 - 1
 // f'(z) = 3z^2
-func NewtonTwo(inColor bool) NewtonFunc {
-	f := func(x complex128) complex128 {
+var pairTwo = validPair{
+	f: func(x complex128) complex128 {
 		return x*x*x - 1
-	}
-	d := func (x complex128) complex128 {
-		return 3*x*x
-	}
-	a := complex(1.0, 0)
+	},
+	d: func(x complex128) complex128 {
+		return 3 * x * x
+	},
+	start: 1,
+}
+
+// f(z) = 5cos(3z)
+// f'(z) = -15sin(3z)
+var pairThree = validPair{
+	f: func(x complex128) complex128 {
+		return 5 * cmplx.Cos(3*x)
+	},
+	d: func(x complex128) complex128 {
+		return -15 * cmplx.Sin(3*x)
+	},
+	start: complex(math.Pi/6, 0),
+}
+
+// f(z) = ln(x)
+// f'(z) = 1/x
+var pairFour = validPair{
+	f: func(x complex128) complex128 {
+		return cmplx.Log(x)
+	},
+	d: func(x complex128) complex128 {
+		return 1 / x
+	},
+	start: 1,
+}
+
+// f(z) = cosh(z) - 1
+// f'(z) = sinh(z)
+// The roots of f are all double roots where f' vanishes too, so Nova
+// orbits start from 1 instead.
+var pairSix = validPair{
+	f: func(x complex128) complex128 {
+		return cmplx.Cosh(x) - 1
+	},
+	d: func(x complex128) complex128 {
+		return cmplx.Sinh(x)
+	},
+	start: 1,
+}
+
+// A Newton fractal: a function, its derivative and the relaxation a.
+type NewtonSystem struct {
+	a    complex128
+	pair validPair
+}
+
+var (
+	// f(z) = z^4 - 1
+	NewtonSystemOne = NewtonSystem{complex(1.0, 0), pairOne}
+	// f(z) = z^3 - 1
+	NewtonSystemTwo = NewtonSystem{complex(1.0, 0), pairTwo}
+	// f(z) = 5cos(3z)
+	NewtonSystemThree = NewtonSystem{complex(1.0, 0), pairThree}
+	// f(z) = ln(z)
+	NewtonSystemFour = NewtonSystem{complex(1.0, 0), pairFour}
+	// f(z) = z^3 - 1, a = 2
+	NewtonSystemFive = NewtonSystem{complex(2, 0), pairTwo}
+	// f(z) = cosh(z) - 1
+	NewtonSystemSix = NewtonSystem{complex(1, 0), pairSix}
+)
+
+func (n NewtonSystem) Newton(inColor bool) NewtonFunc {
+	a, f, d := n.a, n.pair.f, n.pair.d
 	if inColor {
-		return func(z complex128)  color.Color {
+		return func(z complex128) color.Color {
 			return newtonColor(z, a, f, d)
 		}
 	}
@@ -686,83 +572,111 @@ func NewtonTwo(inColor bool) NewtonFunc {
 	}
 }
 
+// The smooth count for an orbit that came within r < tolerance of a root
+// at iteration i. Convergence is quadratic near a simple root, which keeps
+// it between i and i + 1.
+func convergedIteration(i int, r, tolerance float64) float64 {
+	nu := float64(i) + 1 - math.Log(math.Log(r)/math.Log(tolerance))/math.Ln2
+	return math.Max(nu, 0)
+}
+
+// Returns the smooth number of iterations taken to converge, or the
+// iteration limit for points that do not.
+func (n NewtonSystem) Value() ValueFunc {
+	const iterations = 200
+	const tolerance = 0.001
+
+	a, f, d := n.a, n.pair.f, n.pair.d
+	return func(z complex128) float64 {
+		for i := 0; i < iterations; i++ {
+			numerator := f(z)
+			z = z - a*(numerator/d(z))
+			if r := cmplx.Abs(numerator); r < tolerance {
+				return convergedIteration(i, r, tolerance)
+			}
+		}
+		return iterations
+	}
+}
+
+// f(z) = z^4 - 1
+// f'(z) = 4z^3
+func NewtonOne(inColor bool) NewtonFunc {
+	return NewtonSystemOne.Newton(inColor)
+}
+
+// f(z) = z^3 - 1
+// f'(z) = 3z^2
+func NewtonTwo(inColor bool) NewtonFunc {
+	return NewtonSystemTwo.Newton(inColor)
+}
+
 // f(z) = 5cos(3z)
 // f'(z) = -15sin(3z)
 func NewtonThree(inColor bool) NewtonFunc {
-	f := func(x complex128) complex128 {
-		return 5*cmplx.Cos(3*x)
-	}
-	d := func (x complex128) complex128 {
-		return -15*cmplx.Sin(3*x)
-	}
-	a := complex(1.0, 0)
-	if inColor {
-		return func(z complex128)  color.Color {
-			return newtonColor(z, a, f, d)
-		}
-	}
-	return func(z complex128) color.Color {
-		return newtonMonochrome(z, a, f, d)
-	}
+	return NewtonSystemThree.Newton(inColor)
 }
 
 // f(z) = ln(x)
 // f'(z) = 1/x
 func NewtonFour(inColor bool) NewtonFunc {
-	f := func(x complex128) complex128 {
-		return cmplx.Log(x)
-	}
-	d := func (x complex128) complex128 {
-		return 1/x
-	}
-	a := complex(1.0, 0)
-	if inColor {
-		return func(z complex128)  color.Color {
-			return newtonColor(z, a, f, d)
-		}
-	}
-	return func(z complex128) color.Color {
-		return newtonMonochrome(z, a, f, d)
-	}
+	return NewtonSystemFour.Newton(inColor)
 }
 
 // f(z) = z^3 - 1
 // f'(z) = 3z^2
 // a = 2
 func NewtonFive(inColor bool) NewtonFunc {
-	f := func(x complex128) complex128 {
-		return x*x*x - 1
-	}
-	d := func (x complex128) complex128 {
-		return 3*x*x
-	}
-	a := complex(2, 0)
-	if inColor {
-		return func(z complex128)  color.Color {
-			return newtonColor(z, a, f, d)
-		}
-	}
-	return func(z complex128) color.Color {
-		return newtonMonochrome(z, a, f, d)
-	}
+	return NewtonSystemFive.Newton(inColor)
 }
 
 // f(z) = cosh(z) - 1
 // f'(z) = sinh(z)
 func NewtonSix(inColor bool) NewtonFunc {
-	f := func(x complex128) complex128 {
-		return cmplx.Cosh(x) - 1
-	}
-	d := func (x complex128) complex128 {
-		return cmplx.Sinh(x)
+	return NewtonSystemSix.Newton(inColor)
+}
+
+// Nova fractals
+
+// z = z - a*f(z)/f'(z) + c
+// The orbit has settled once consecutive values stop moving; unlike plain
+// Newton, the fixed point is no longer a root of f.
+func nova(
+	z, c, a complex128,
+	function, derivative validFunc,
+	inColor bool,
+) color.Color {
+	const iterations = 200
+	const contrast = 15
+
+	for n := uint8(0); n < iterations; n++ {
+		next := z - a*(function(z)/derivative(z)) + c
+		if cmplx.Abs(next-z) < 0.001 {
+			if inColor {
+				return colorful.Hsv(float64(contrast*n), 50, 100)
+			}
+			return color.Gray{255 - contrast*n}
+		}
+		if cmplx.IsNaN(next) || cmplx.IsInf(next) {
+			break
+		}
+		z = next
 	}
-	a := complex(1, 0)
-	if inColor {
-		return func(z complex128)  color.Color {
-			return newtonColor(z, a, f, d)
+	return color.Black
+}
+
+// Mandelbrot-style Nova uses the pixel as c and starts every orbit from
+// the same point. Julia-style Nova starts from the pixel and adds the
+// fixed constant k.
+func (n NewtonSystem) Nova(inColor, julia bool, k complex128) NewtonFunc {
+	a, f, d := n.a, n.pair.f, n.pair.d
+	if julia {
+		return func(z complex128) color.Color {
+			return nova(z, k, a, f, d, inColor)
 		}
 	}
-	return func(z complex128) color.Color {
-		return newtonMonochrome(z, a, f, d)
+	start := n.pair.start
+	return func(c complex128) color.Color {
+		return nova(start, c, a, f, d, inColor)
 	}
 }
@@ -0,0 +1,317 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// SVG output. Geometric fractals are written as paths directly; escape-time
+// fractals are traced into contour lines of their smooth iteration count.
+
+type ValueFunc func(complex128) float64
+
+// Returns the smooth escape count for an escape-time variant, with the
+// same fallback as GetMandelFunc.
+func GetValueFunc(fractalType string) ValueFunc {
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	return v.value
+}
+
+type ValueFuncHP func(x, y *big.Float) float64
+
+// Like GetValueFunc, following orbits at high precision.
+func GetValueFuncHP(a *Arithmetic, fractalType string) ValueFuncHP {
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	return func(x, y *big.Float) float64 {
+		return v.valueHP(a, x, y)
+	}
+}
+
+// Per-pixel values of a frame, stored row by row.
+type Field struct {
+	Width, Height int
+	Values        []float64
+}
+
+func (f *Field) At(px, py int) float64 {
+	return f.Values[py*f.Width+px]
+}
+
+// Samples v at the same points as RenderMFrame, one band of rows per
+// goroutine.
+func RenderField(width, height int, f FrameInfo, v ValueFunc) <-chan *Field {
+	const bands = 4
+
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	log.Printf("rendering field (%f, %f), (%f, %f)\n", xmin, ymin, xmax, ymax)
+	c := make(chan *Field)
+	go func() {
+		field := &Field{
+			Width:  width,
+			Height: height,
+			Values: make([]float64, width*height),
+		}
+		var wg sync.WaitGroup
+		for b := 0; b < bands; b++ {
+			wg.Add(1)
+			go func(b int) {
+				defer wg.Done()
+				for py := b * height / bands; py < (b+1)*height/bands; py++ {
+					y := float64(py)/float64(height)*(ymax-ymin) + ymin
+					for px := 0; px < width; px++ {
+						x := float64(px)/float64(width)*(xmax-xmin) + xmin
+						field.Values[py*width+px] = v(complex(x, y))
+					}
+				}
+			}(b)
+		}
+		wg.Wait()
+		c <- field
+	}()
+	return c
+}
+
+type point struct {
+	x, y float64
+}
+
+// Joins segments that share endpoints into polylines.
+func chainSegments(segments [][2]point) [][]point {
+	ends := make(map[point][]int)
+	for i, s := range segments {
+		ends[s[0]] = append(ends[s[0]], i)
+		ends[s[1]] = append(ends[s[1]], i)
+	}
+	used := make([]bool, len(segments))
+	// Returns an unused segment touching p and its other end.
+	next := func(p point) (point, bool) {
+		for _, i := range ends[p] {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+			if segments[i][0] == p {
+				return segments[i][1], true
+			}
+			return segments[i][0], true
+		}
+		return point{}, false
+	}
+
+	var lines [][]point
+	for i, s := range segments {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		line := []point{s[0], s[1]}
+		for p, ok := next(s[1]); ok; p, ok = next(p) {
+			line = append(line, p)
+		}
+		// Extend backwards too, in case we started mid-line.
+		var head []point
+		for p, ok := next(s[0]); ok; p, ok = next(p) {
+			head = append(head, p)
+		}
+		for j, k := 0, len(head)-1; j < k; j, k = j+1, k-1 {
+			head[j], head[k] = head[k], head[j]
+		}
+		lines = append(lines, append(head, line...))
+	}
+	return lines
+}
+
+// Marching squares over the field at a single level. Sample (px, py) sits
+// at the point (px, py) of the output.
+func contourSegments(f *Field, level float64) [][2]point {
+	// Crossing on the edge between two samples. Always called with the
+	// samples in the same order so shared edges give identical points.
+	cross := func(x0, y0, x1, y1 int) point {
+		v0, v1 := f.At(x0, y0), f.At(x1, y1)
+		t := 0.5
+		if v1 != v0 {
+			t = (level - v0) / (v1 - v0)
+		}
+		return point{
+			float64(x0) + t*float64(x1-x0),
+			float64(y0) + t*float64(y1-y0),
+		}
+	}
+
+	var segments [][2]point
+	for py := 0; py+1 < f.Height; py++ {
+		for px := 0; px+1 < f.Width; px++ {
+			// Corners clockwise from the top left.
+			tl := f.At(px, py) >= level
+			tr := f.At(px+1, py) >= level
+			br := f.At(px+1, py+1) >= level
+			bl := f.At(px, py+1) >= level
+
+			top := func() point { return cross(px, py, px+1, py) }
+			right := func() point { return cross(px+1, py, px+1, py+1) }
+			bottom := func() point { return cross(px, py+1, px+1, py+1) }
+			left := func() point { return cross(px, py, px, py+1) }
+
+			var c int
+			for i, b := range []bool{tl, tr, br, bl} {
+				if b {
+					c |= 8 >> i
+				}
+			}
+			switch c {
+			case 0, 15:
+			case 1, 14:
+				segments = append(segments, [2]point{left(), bottom()})
+			case 2, 13:
+				segments = append(segments, [2]point{bottom(), right()})
+			case 3, 12:
+				segments = append(segments, [2]point{left(), right()})
+			case 4, 11:
+				segments = append(segments, [2]point{top(), right()})
+			case 6, 9:
+				segments = append(segments, [2]point{top(), bottom()})
+			case 7, 8:
+				segments = append(segments, [2]point{left(), top()})
+			case 5, 10:
+				// Saddle; the average of the corners decides which
+				// pair of corners is connected.
+				centre := (f.At(px, py) + f.At(px+1, py) +
+					f.At(px+1, py+1) + f.At(px, py+1)) / 4
+				if (centre >= level) == (c == 10) {
+					segments = append(segments,
+						[2]point{left(), bottom()},
+						[2]point{top(), right()},
+					)
+				} else {
+					segments = append(segments,
+						[2]point{left(), top()},
+						[2]point{bottom(), right()},
+					)
+				}
+			}
+		}
+	}
+	return segments
+}
+
+func writePath(buf *bytes.Buffer, lines [][]point, stroke string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, `<path fill="none" stroke="%s" stroke-width="1" d="`, stroke)
+	for _, line := range lines {
+		for i, p := range line {
+			cmd := 'L'
+			if i == 0 {
+				cmd = 'M'
+			}
+			fmt.Fprintf(buf, "%c%.3f %.3f", cmd, p.x, p.y)
+		}
+	}
+	buf.WriteString(`"/>` + "\n")
+}
+
+func svgHeader(buf *bytes.Buffer, width, height int) {
+	fmt.Fprintf(buf,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height,
+	)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="black"/>`+"\n", width, height)
+}
+
+func strokeColor(col colorful.Color) string {
+	return col.Clamped().Hex()
+}
+
+// Each level is a pass over the whole field, so requests for more are
+// refused.
+const MaxContourLevels = 256
+
+// Traces contours at levels chosen so that each band between them holds
+// about the same number of pixels, one coloured layer per level.
+func ContourSVG(f *Field, levels int, colorized bool) []byte {
+	values := make([]float64, 0, len(f.Values))
+	for _, v := range f.Values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			values = append(values, v)
+		}
+	}
+	sort.Float64s(values)
+
+	buf := new(bytes.Buffer)
+	svgHeader(buf, f.Width, f.Height)
+	last := math.Inf(-1)
+	for i := 1; i <= levels && len(values) > 0; i++ {
+		level := values[i*(len(values)-1)/(levels+1)]
+		if level <= last || level == values[len(values)-1] {
+			continue
+		}
+		last = level
+		lines := chainSegments(contourSegments(f, level))
+		col, _ := colorful.MakeColor(smoothColor(level, colorized))
+		fmt.Fprintf(buf, `<g id="level-%d">`+"\n", i)
+		writePath(buf, lines, strokeColor(col))
+		buf.WriteString("</g>\n")
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// Writes segments as paths, using the same mapping from the frame to the
+// image as RenderSegments. Colourised drawings are split into layers along
+// the same gradient.
+func SegmentsSVG(
+	width, height int, f FrameInfo, segments []Segment, colorized bool,
+) []byte {
+	const layers = 32
+
+	_, xmin, ymin, xmax, ymax, _, _ := f.Read()
+	xscale := float64(width) / (xmax - xmin)
+	yscale := float64(height) / (ymax - ymin)
+	toPoint := func(x, y float64) point {
+		p := point{(x - xmin) * xscale, (-y - ymin) * yscale}
+		// Rounded so that turtle steps which should meet do.
+		p.x = math.Round(p.x*1000) / 1000
+		p.y = math.Round(p.y*1000) / 1000
+		return p
+	}
+
+	groups := 1
+	if colorized {
+		groups = layers
+	}
+	buf := new(bytes.Buffer)
+	svgHeader(buf, width, height)
+	for g := 0; g < groups; g++ {
+		lo, hi := g*len(segments)/groups, (g+1)*len(segments)/groups
+		var lines [][]point
+		for _, s := range segments[lo:hi] {
+			p0, p1 := toPoint(s.X0, s.Y0), toPoint(s.X1, s.Y1)
+			n := len(lines)
+			if n > 0 && lines[n-1][len(lines[n-1])-1] == p0 {
+				lines[n-1] = append(lines[n-1], p1)
+			} else {
+				lines = append(lines, []point{p0, p1})
+			}
+		}
+		stroke := "#ffffff"
+		if colorized {
+			stroke = strokeColor(colorful.Hsv(300*float64(g)/layers, 0.8, 1))
+		}
+		writePath(buf, lines, stroke)
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,98 @@
+package render
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every corner pattern of a single cell at level 0.5, with corners of 0
+// or 1, so that every crossing is at the middle of its edge.
+func TestContourSegmentsCases(t *testing.T) {
+	var (
+		top    = point{0.5, 0}
+		right  = point{1, 0.5}
+		bottom = point{0.5, 1}
+		left   = point{0, 0.5}
+	)
+	// Indexed by the corners at or above the level: 8 for the top left,
+	// then clockwise.
+	want := [16][][2]point{
+		1:  {{left, bottom}},
+		2:  {{bottom, right}},
+		3:  {{left, right}},
+		4:  {{top, right}},
+		5:  {{left, top}, {bottom, right}},
+		6:  {{top, bottom}},
+		7:  {{left, top}},
+		8:  {{left, top}},
+		9:  {{top, bottom}},
+		10: {{left, bottom}, {top, right}},
+		11: {{top, right}},
+		12: {{left, right}},
+		13: {{bottom, right}},
+		14: {{left, bottom}},
+	}
+	for c := range want {
+		corner := func(bit int) float64 {
+			return float64(c >> bit & 1)
+		}
+		f := &Field{
+			Width:  2,
+			Height: 2,
+			// Top left, top right, bottom left, bottom right.
+			Values: []float64{corner(3), corner(2), corner(0), corner(1)},
+		}
+		got := contourSegments(f, 0.5)
+		if !reflect.DeepEqual(got, want[c]) {
+			t.Errorf("case %d: got %v, want %v", c, got, want[c])
+		}
+	}
+}
+
+// Crossings are interpolated along the edge.
+func TestContourSegmentsInterpolate(t *testing.T) {
+	f := &Field{Width: 2, Height: 2, Values: []float64{0, 4, 0, 4}}
+	got := contourSegments(f, 1)
+	want := [][2]point{{{0.25, 0}, {0.25, 1}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// A cone traced at one level closes into a single loop around its peak.
+func TestContourLoop(t *testing.T) {
+	const size = 16
+
+	f := &Field{Width: size, Height: size, Values: make([]float64, size*size)}
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			f.Values[py*size+px] = -math.Hypot(float64(px)-7.3, float64(py)-7.6)
+		}
+	}
+	lines := chainSegments(contourSegments(f, -5))
+	if len(lines) != 1 {
+		t.Fatalf("%d lines, want 1", len(lines))
+	}
+	line := lines[0]
+	if line[0] != line[len(line)-1] {
+		t.Errorf("loop starts at %v and ends at %v", line[0], line[len(line)-1])
+	}
+	for _, p := range line {
+		if r := math.Hypot(p.x-7.3, p.y-7.6); math.Abs(r-5) > 0.1 {
+			t.Errorf("point %v is %g from the peak, want 5", p, r)
+		}
+	}
+}
+
+func TestContourSVG(t *testing.T) {
+	f := &Field{Width: 2, Height: 2, Values: []float64{0, 1, 2, 3}}
+	svg := string(ContourSVG(f, 1, false))
+	if !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>\n") {
+		t.Errorf("not an svg document:\n%s", svg)
+	}
+	if n := strings.Count(svg, "<path "); n != 1 {
+		t.Errorf("%d paths, want 1:\n%s", n, svg)
+	}
+}
@@ -0,0 +1,292 @@
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strings"
+)
+
+// Orbit traps colour each point by how close its orbit comes to a shape in
+// the plane, rather than by how long it takes to escape or converge.
+
+// A trap as given in a request. Coordinates follow requests, with y
+// pointing up.
+type Trap struct {
+	// "point", "line", "cross", "circle" or "image".
+	Shape string  `json:"shape"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	// Direction of a line, or of one arm of a cross, in degrees.
+	Angle  float64 `json:"angle"`
+	Radius float64 `json:"radius"`
+	// Distance over which the colour fades out. Defaults to 0.1.
+	Width float64 `json:"width"`
+	// Base64 encoded PNG or JPEG for image traps. A data URL also works.
+	Image string `json:"image"`
+	// Side of the square, centred on (X, Y), that the image covers.
+	// Defaults to 1.
+	Scale float64 `json:"scale"`
+}
+
+type OrbitTrap struct {
+	// Distance from z to the shape. Nil for image traps.
+	distance func(z complex128) float64
+	width    float64
+
+	texture image.Image
+	// Top left corner of the texture in the plane.
+	corner complex128
+	scale  float64
+}
+
+func NewOrbitTrap(t Trap) (*OrbitTrap, error) {
+	width := t.Width
+	if width <= 0 {
+		width = 0.1
+	}
+	// The pixel with imaginary part -y is drawn at height y.
+	p := complex(t.X, -t.Y)
+	a := t.Angle * math.Pi / 180
+	dir := complex(math.Cos(a), -math.Sin(a))
+	// Distance from a line through p along dir.
+	line := func(z, dir complex128) float64 {
+		return math.Abs(imag((z - p) * cmplx.Conj(dir)))
+	}
+
+	o := &OrbitTrap{width: width}
+	switch t.Shape {
+	case "point", "":
+		o.distance = func(z complex128) float64 {
+			return cmplx.Abs(z - p)
+		}
+	case "line":
+		o.distance = func(z complex128) float64 {
+			return line(z, dir)
+		}
+	case "cross":
+		o.distance = func(z complex128) float64 {
+			return math.Min(line(z, dir), line(z, dir*1i))
+		}
+	case "circle":
+		if t.Radius <= 0 {
+			return nil, fmt.Errorf("circle trap needs a positive radius")
+		}
+		o.distance = func(z complex128) float64 {
+			return math.Abs(cmplx.Abs(z-p) - t.Radius)
+		}
+	case "image":
+		texture, err := decodeTexture(t.Image)
+		if err != nil {
+			return nil, err
+		}
+		o.texture = texture
+		o.scale = t.Scale
+		if o.scale <= 0 {
+			o.scale = 1
+		}
+		o.corner = p - complex(o.scale/2, o.scale/2)
+	default:
+		return nil, fmt.Errorf("unknown trap shape %q", t.Shape)
+	}
+	return o, nil
+}
+
+// Bounds on a trap image, checked before it is decoded. A small file can
+// declare a very large image.
+const (
+	maxTextureBytes  = 16 << 20
+	maxTexturePixels = 4096 * 4096
+)
+
+func decodeTexture(data string) (image.Image, error) {
+	if i := strings.IndexByte(data, ','); strings.HasPrefix(data, "data:") && i >= 0 {
+		data = data[i+1:]
+	}
+	if n := base64.StdEncoding.DecodedLen(len(data)); n > maxTextureBytes {
+		return nil, fmt.Errorf(
+			"trap image: %d bytes is over the limit of %d", n, maxTextureBytes,
+		)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("trap image: %v", err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("trap image: %v", err)
+	}
+	if n := config.Width * config.Height; n > maxTexturePixels {
+		return nil, fmt.Errorf(
+			"trap image: %dx%d is over the limit of %d pixels",
+			config.Width, config.Height, maxTexturePixels,
+		)
+	}
+	texture, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("trap image: %v", err)
+	}
+	return texture, nil
+}
+
+// Returns the texel under z, or false if z is off the texture or the
+// texel is transparent.
+func (o *OrbitTrap) sample(z complex128) (color.Color, bool) {
+	b := o.texture.Bounds()
+	u := (z - o.corner) / complex(o.scale, 0)
+	if real(u) < 0 || real(u) >= 1 || imag(u) < 0 || imag(u) >= 1 {
+		return nil, false
+	}
+	px := b.Min.X + int(real(u)*float64(b.Dx()))
+	py := b.Min.Y + int(imag(u)*float64(b.Dy()))
+	col := o.texture.At(px, py)
+	if _, _, _, alpha := col.RGBA(); alpha == 0 {
+		return nil, false
+	}
+	return col, true
+}
+
+// The state of one orbit as it passes the trap.
+type trapOrbit struct {
+	trap   *OrbitTrap
+	min    float64
+	caught color.Color
+}
+
+func (o *OrbitTrap) orbit() trapOrbit {
+	return trapOrbit{trap: o, min: math.Inf(1)}
+}
+
+// Records the next point of the orbit. Returns true once an image trap
+// has caught the orbit, after which the rest of it does not matter.
+func (t *trapOrbit) visit(z complex128) bool {
+	if t.trap.texture != nil {
+		col, ok := t.trap.sample(z)
+		t.caught = col
+		return ok
+	}
+	if d := t.trap.distance(z); d < t.min {
+		t.min = d
+	}
+	return false
+}
+
+func (t *trapOrbit) color(colorized bool) color.Color {
+	if t.trap.texture != nil {
+		if t.caught == nil {
+			return color.Black
+		}
+		if colorized {
+			return t.caught
+		}
+		return color.GrayModel.Convert(t.caught)
+	}
+	// Orbits close to the trap are bright and red, far ones fade to
+	// dark blue.
+	v := math.Exp(-t.min / t.trap.width)
+	if colorized {
+		return colorful.Hsv(240*(1-v), 0.8, math.Sqrt(v))
+	}
+	return color.Gray{uint8(255 * v)}
+}
+
+// Follows z under step until it escapes past radius, is caught or runs
+// out of iterations.
+func (o *OrbitTrap) escapeTime(
+	z, c complex128,
+	step func(z, c complex128) complex128,
+	radius float64,
+	colorized bool,
+) color.Color {
+	const iterations = 100
+
+	t := o.orbit()
+	for n := 0; n < iterations; n++ {
+		z = step(z, c)
+		if cmplx.Abs(z) > radius || t.visit(z) {
+			break
+		}
+	}
+	return t.color(colorized)
+}
+
+// Traps the orbits of an escape-time variant, or of the standard
+// Mandelbrot set if fractalType is not one.
+func GetTrapFunc(fractalType string, o *OrbitTrap, colorized bool) MandelFunc {
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	return func(c complex128) color.Color {
+		return o.escapeTime(0, c, v.step, 2, colorized)
+	}
+}
+
+// Like GetTrapFunc for high precision renders. The orbit is followed at
+// high precision and trapped in float64.
+func GetTrapFuncHP(
+	a *Arithmetic, fractalType string, o *OrbitTrap, colorized bool,
+) MandelFuncHP {
+	const iterations = 100
+
+	v, ok := variants[fractalType]
+	if !ok {
+		v = variants["mandelbrot"]
+	}
+	return func(cR, cI *big.Float) color.Color {
+		orbit, _ := a.orbit(v, cR, cI, iterations, 0, 2)
+		t := o.orbit()
+		for _, z := range orbit {
+			if cmplx.Abs(z) > 2 || t.visit(z) {
+				break
+			}
+		}
+		return t.color(colorized)
+	}
+}
+
+// Traps the orbits of z = z^d + c, Mandelbrot or Julia-style as in
+// GetMultibrotFunc.
+func GetMultibrotTrapFunc(
+	d complex128, julia bool, k complex128, o *OrbitTrap, colorized bool,
+) MandelFunc {
+	pow := powFunc(d)
+	step := func(z, c complex128) complex128 {
+		return pow(z) + c
+	}
+	radius := escapeRadius(d)
+	if julia {
+		return func(z complex128) color.Color {
+			return o.escapeTime(z, k, step, radius, colorized)
+		}
+	}
+	return func(c complex128) color.Color {
+		return o.escapeTime(0, c, step, radius, colorized)
+	}
+}
+
+// Traps the Newton iterates of each point until they converge.
+func (n NewtonSystem) Trap(o *OrbitTrap, colorized bool) NewtonFunc {
+	const iterations = 200
+
+	a, f, d := n.a, n.pair.f, n.pair.d
+	return func(z complex128) color.Color {
+		t := o.orbit()
+		for i := 0; i < iterations; i++ {
+			numerator := f(z)
+			z = z - a*(numerator/d(z))
+			if t.visit(z) || cmplx.Abs(numerator) < 0.001 {
+				break
+			}
+		}
+		return t.color(colorized)
+	}
+}
@@ -0,0 +1,190 @@
+package render
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Escape-time variants of z = z^2 + c. Each one folds z (or z^2) with
+// absolute values or a conjugate, which is all that separates them from
+// the standard Mandelbrot set.
+type variant struct {
+	// Applied to z before squaring.
+	absX, absY, conj bool
+	// Applied to z^2 before c is added.
+	absRe, negIm bool
+}
+
+var variants = map[string]variant{
+	// z = z^2 + c
+	"mandelbrot": {},
+	// z = (|x| + i|y|)^2 + c
+	"burningShip": {absX: true, absY: true},
+	// z = conj(z)^2 + c
+	"tricorn": {conj: true},
+	// z = |x^2 - y^2| + i2xy + c
+	"celtic": {absRe: true},
+	// z = x^2 - y^2 - i2|x|y + c
+	"perpendicular": {absX: true, conj: true},
+	// z = |x^2 - y^2| - i2|xy| + c
+	"buffalo": {absX: true, absY: true, absRe: true, negIm: true},
+}
+
+func IsVariant(fractalType string) bool {
+	_, ok := variants[fractalType]
+	return ok
+}
+
+func escapeColor(n uint8, colorized bool) color.Color {
+	const contrast = 15
+	if colorized {
+		return colorful.Hsv(float64(contrast*n), 50, 100)
+	}
+	return color.Gray{255 - contrast*n}
+}
+
+// One step of the orbit: folds z, squares it and adds c.
+func (v variant) step(z, c complex128) complex128 {
+	x, y := real(z), imag(z)
+	if v.absX {
+		x = math.Abs(x)
+	}
+	if v.absY {
+		y = math.Abs(y)
+	}
+	if v.conj {
+		y = -y
+	}
+	re, im := x*x-y*y, 2*x*y
+	if v.absRe {
+		re = math.Abs(re)
+	}
+	if v.negIm {
+		im = -im
+	}
+	return complex(re+real(c), im+imag(c))
+}
+
+// Returns the iteration at which the orbit of c escaped and the value it
+// escaped with.
+func (v variant) iterate(c complex128) (uint8, complex128, bool) {
+	const iterations = 100
+
+	var z complex128
+	for n := uint8(0); n < iterations; n++ {
+		z = v.step(z, c)
+		if real(z)*real(z)+imag(z)*imag(z) > 4 {
+			return n, z, true
+		}
+	}
+	return 0, z, false
+}
+
+// Follows the orbit of c at high precision and returns each point of it,
+// rounded to complex128, with the iteration at which it passed 2, or -1.
+// Orbits that pass 2 go on for up to extra more steps while they stay
+// within radius, for colourings that need a larger bailout. The points
+// are only used for colouring, where float64 is plenty.
+func (v variant) orbitHP(
+	cR, cI *big.Float, iterations, extra int, radius float64,
+) ([]complex128, int) {
+	two := big.NewFloat(2)
+	// At the precision of c from the start. A zero precision would be
+	// taken from two on the first step, rounding c to float64.
+	vR := new(big.Float).SetPrec(cR.Prec())
+	vI := new(big.Float).SetPrec(cI.Prec())
+	orbit := make([]complex128, 0, iterations)
+	escape := -1
+	for n := 0; escape >= 0 || n < iterations; n++ {
+		if v.absX {
+			vR.Abs(vR)
+		}
+		if v.absY {
+			vI.Abs(vI)
+		}
+		if v.conj {
+			vI.Neg(vI)
+		}
+		vR2, vI2 := new(big.Float), new(big.Float)
+		vR2.Mul(vR, vR).Sub(vR2, new(big.Float).Mul(vI, vI))
+		vI2.Mul(vR, vI).Mul(vI2, two)
+		if v.absRe {
+			vR2.Abs(vR2)
+		}
+		if v.negIm {
+			vI2.Neg(vI2)
+		}
+		vR, vI = vR2.Add(vR2, cR), vI2.Add(vI2, cI)
+
+		z := complexHP(vR, vI)
+		orbit = append(orbit, z)
+		if escape < 0 && real(z)*real(z)+imag(z)*imag(z) > 4 {
+			escape = n
+		}
+		if escape >= 0 && (n-escape >= extra || cmplx.Abs(z) > radius) {
+			break
+		}
+	}
+	return orbit, escape
+}
+
+func complexHP(x, y *big.Float) complex128 {
+	re, _ := x.Float64()
+	im, _ := y.Float64()
+	return complex(re, im)
+}
+
+func (v variant) iterateHP(a *Arithmetic, zR, zI *big.Float) (uint8, bool) {
+	const iterations = 100
+
+	_, n := a.orbit(v, zR, zI, iterations, 0, 2)
+	if n < 0 {
+		return 0, false
+	}
+	return uint8(n), true
+}
+
+func (v variant) mandelFunc(colorized bool) MandelFunc {
+	return func(z complex128) color.Color {
+		n, _, escaped := v.iterate(z)
+		if !escaped {
+			return color.Black
+		}
+		return escapeColor(n, colorized)
+	}
+}
+
+func (v variant) mandelFuncHP(a *Arithmetic, colorized bool) MandelFuncHP {
+	return func(zR, zI *big.Float) color.Color {
+		n, escaped := v.iterateHP(a, zR, zI)
+		if !escaped {
+			return color.Black
+		}
+		return escapeColor(n, colorized)
+	}
+}
+
+// The smooth escape count, or the iteration limit for points that do not
+// escape.
+func (v variant) value(c complex128) float64 {
+	const iterations = 100
+
+	n, z, escaped := v.iterate(c)
+	if !escaped {
+		return iterations
+	}
+	return smoothIteration(int(n), z, 2, 2)
+}
+
+func (v variant) valueHP(a *Arithmetic, cR, cI *big.Float) float64 {
+	const iterations = 100
+
+	orbit, n := a.orbit(v, cR, cI, iterations, 0, 2)
+	if n < 0 {
+		return iterations
+	}
+	return smoothIteration(n, orbit[n], 2, 2)
+}
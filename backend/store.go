@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Ricefrog/fractalHeaven/render"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Buffers kept in memory. A buffer for a 1024x1024 frame takes about
+	// 22MB.
+	memoryBuffers = 4
+	// Buffers kept on disk once they leave memory.
+	diskBuffers = 32
+	// Buffers unused for this long are dropped.
+	bufferExpiry = 30 * time.Minute
+)
+
+var errUnknownHandle = fmt.Errorf("unknown or expired frame handle")
+
+// Computed buffers, kept so that frames can be recoloured. The most
+// recently used buffers stay in memory and older ones are written to dir,
+// to be read back when they are asked for again.
+type bufferStore struct {
+	sync.Mutex
+	dir     string
+	entries map[string]*storeEntry
+}
+
+type storeEntry struct {
+	// Nil while the buffer is only on disk.
+	buffer *render.Buffer
+	onDisk bool
+	used   time.Time
+	// Colouring used when a request does not name one.
+	mode string
+}
+
+var store *bufferStore
+
+func newBufferStore(dir string) *bufferStore {
+	// Files left by an earlier run have no entries to find them by.
+	old, _ := filepath.Glob(filepath.Join(dir, "*.buf"))
+	for _, name := range old {
+		os.Remove(name)
+	}
+	return &bufferStore{dir: dir, entries: make(map[string]*storeEntry)}
+}
+
+// Everything in a request that changes the compute stage. Buffers are
+// stored at the size of renders only.
+func bufferKey(s requestStruct) string {
+	tier, _ := precision(s, WIDTH)
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%t|%g|%g|%g|%g",
+		s.FractalType, s.FunctionToUse,
+		s.xText, s.yText, s.zoomText, tier,
+		s.Julia, s.Cr, s.Ci,
+		s.Power, s.PowerImag,
+	)
+}
+
+// The same frame always gets the same handle.
+func frameHandle(s requestStruct) string {
+	sum := sha256.Sum256([]byte(bufferKey(s)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (st *bufferStore) path(handle string) string {
+	return filepath.Join(st.dir, handle+".buf")
+}
+
+func (st *bufferStore) put(handle string, b *render.Buffer, mode string) {
+	st.Lock()
+	defer st.Unlock()
+	if _, ok := st.entries[handle]; ok {
+		st.drop(handle)
+	}
+	st.entries[handle] = &storeEntry{buffer: b, used: time.Now(), mode: mode}
+	st.evict()
+}
+
+// Returns the buffer for handle and the colouring to use by default.
+func (st *bufferStore) get(handle string) (*render.Buffer, string, error) {
+	st.Lock()
+	defer st.Unlock()
+	st.evict()
+	e, ok := st.entries[handle]
+	if !ok {
+		return nil, "", errUnknownHandle
+	}
+	if e.buffer == nil {
+		b, err := st.load(handle)
+		if err != nil {
+			st.drop(handle)
+			return nil, "", err
+		}
+		e.buffer = b
+	}
+	e.used = time.Now()
+	st.evict()
+	return e.buffer, e.mode, nil
+}
+
+// Drops expired entries, then moves the least recently used buffers to
+// disk and off it to stay within bounds. The lock must be held.
+func (st *bufferStore) evict() {
+	handles := make([]string, 0, len(st.entries))
+	for h, e := range st.entries {
+		if time.Since(e.used) > bufferExpiry {
+			st.drop(h)
+			continue
+		}
+		handles = append(handles, h)
+	}
+	sort.Slice(handles, func(i, j int) bool {
+		return st.entries[handles[i]].used.After(st.entries[handles[j]].used)
+	})
+
+	inMemory, onDisk := 0, 0
+	for _, h := range handles {
+		e := st.entries[h]
+		if e.buffer != nil {
+			inMemory++
+			if inMemory <= memoryBuffers {
+				continue
+			}
+			if err := st.spill(h, e); err != nil {
+				log.Print(err)
+				st.drop(h)
+				continue
+			}
+		}
+		onDisk++
+		if onDisk > diskBuffers {
+			st.drop(h)
+		}
+	}
+}
+
+// Moves the buffer of e to disk. It is only written the first time.
+func (st *bufferStore) spill(handle string, e *storeEntry) error {
+	if !e.onDisk {
+		if err := os.MkdirAll(st.dir, 0700); err != nil {
+			return err
+		}
+		tmp := st.path(handle) + ".tmp"
+		file, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(file)
+		err = render.WriteBuffer(w, e.buffer)
+		if err == nil {
+			err = w.Flush()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp, st.path(handle))
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		e.onDisk = true
+	}
+	e.buffer = nil
+	return nil
+}
+
+func (st *bufferStore) load(handle string) (*render.Buffer, error) {
+	file, err := os.Open(st.path(handle))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return render.ReadBuffer(bufio.NewReader(file))
+}
+
+func (st *bufferStore) drop(handle string) {
+	if st.entries[handle].onDisk {
+		os.Remove(st.path(handle))
+	}
+	delete(st.entries, handle)
+}
@@ -4,21 +4,29 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/Ricefrog/fractal-lib"
 	"image"
 	"image/jpeg"
 	"log"
 	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
 	WIDTH, HEIGHT = 1024, 1024
 	PORT          = "8080"
+	// Bits high precision renders parse the centre and zoom at.
+	HP_PREC = 128
 )
 
 type requestStruct struct {
+	// JSON numbers or decimal strings. High precision renders read every
+	// digit from xText, yText and zoomText.
 	X             float64 `json:"x"`
 	Y             float64 `json:"y"`
 	Zoom          float64 `json:"zoom"`
@@ -27,6 +35,79 @@ type requestStruct struct {
 	Colorized     bool    `json:"colorized"`
 	AntiAliasing  bool    `json:"antiAliasing"`
 	HighPrecision bool    `json:"highPrecision"`
+
+	xText, yText, zoomText string
+}
+
+// A field of a request that is not a decimal number.
+type badNumberError struct {
+	field, text string
+}
+
+func (e *badNumberError) Error() string {
+	return fmt.Sprintf("%s is not a decimal number: %q", e.field, e.text)
+}
+
+// Reads a field the way the backend server does, keeping its digits as
+// text. Empty strings and null read as 0.
+func decimalText(field string, raw json.RawMessage) (string, error) {
+	text := string(raw)
+	if text == "" || text == "null" {
+		return "0", nil
+	}
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", err
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return "0", nil
+		}
+	}
+	if v, ok := new(big.Float).SetString(text); !ok || v.IsInf() {
+		return "", &badNumberError{field, text}
+	}
+	return text, nil
+}
+
+func (s *requestStruct) UnmarshalJSON(data []byte) error {
+	// plain has the fields of requestStruct without this method.
+	type plain requestStruct
+	var r struct {
+		plain
+		X    json.RawMessage `json:"x"`
+		Y    json.RawMessage `json:"y"`
+		Zoom json.RawMessage `json:"zoom"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*s = requestStruct(r.plain)
+	fields := []struct {
+		name  string
+		raw   json.RawMessage
+		text  *string
+		value *float64
+	}{
+		{"x", r.X, &s.xText, &s.X},
+		{"y", r.Y, &s.yText, &s.Y},
+		{"zoom", r.Zoom, &s.zoomText, &s.Zoom},
+	}
+	for _, f := range fields {
+		text, err := decimalText(f.name, f.raw)
+		if err != nil {
+			return err
+		}
+		*f.text = text
+		*f.value, _ = strconv.ParseFloat(text, 64)
+	}
+	return nil
+}
+
+func parseHP(text string) *big.Float {
+	// decimalText has already rejected anything SetString would.
+	v, _ := new(big.Float).SetPrec(HP_PREC).SetString(text)
+	return v
 }
 
 type responseStruct struct {
@@ -88,10 +169,13 @@ func renderMandelbrot(s requestStruct) responseStruct {
 func renderMandelbrotHP(s requestStruct) responseStruct {
 	log.Println("Rendering mandelbrot (high-precision).")
 	start := time.Now()
-	cx, cy := big.NewFloat(s.X), big.NewFloat(-s.Y)
+	cx := parseHP(s.xText)
+	// 0 - y, since negating 0 gives -0.
+	cy := new(big.Float).Sub(new(big.Float), parseHP(s.yText))
 
 	// Distance from the center.
-	startBoundary, zoom := big.NewFloat(2.0), big.NewFloat(s.Zoom)
+	startBoundary := new(big.Float).SetPrec(HP_PREC).SetInt64(2)
+	zoom := parseHP(s.zoomText)
 	boundary := new(big.Float).Quo(startBoundary, zoom)
 
 	xmin := new(big.Float).Sub(cx, boundary)
@@ -227,7 +311,15 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var s requestStruct
 	err := decoder.Decode(&s)
-	if err != nil {
+	var bad *badNumberError
+	var syntax *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &bad) || errors.As(err, &syntax) ||
+		errors.As(err, &typeErr) {
+		w.WriteHeader(400)
+		log.Print(err)
+		return
+	} else if err != nil {
 		w.WriteHeader(500)
 		log.Print(err)
 		return
@@ -23,10 +23,13 @@ const Canvas = ({
 		// percent distance from the center
 		// multiplied by the scaled width between the center and the max bound
 		// plus the center offset
-		let relX = xScale*(renderBounds.xmax-renderBounds.x)+renderBounds.x;
-		let relY = yScale*(renderBounds.ymax-renderBounds.y)-renderBounds.y;
+		let dx = xScale*(renderBounds.xmax-renderBounds.x);
+		let dy = yScale*(renderBounds.ymax-renderBounds.y);
+		let relX = dx+renderBounds.x;
+		let relY = dy-renderBounds.y;
 
-		return {x: relX, y: relY};
+		// The offsets let deep frames add them to their exact centre.
+		return {x: relX, y: relY, dx, dy};
 	}, [canvasRef, renderBounds]);
 
 	const drawPosition = useCallback((ctx, x, y) => {
@@ -96,7 +99,7 @@ const Canvas = ({
 		const handleClick = (event) => {
 			let mousePos = getMousePos(event);
 			let coords = getRelativePosition(mousePos);
-			handleSetPosition({x: coords.x, y: coords.y});
+			handleSetPosition(coords);
 			if (fullscreen) {
 				handleChangeFullscreen(false);
 			}
@@ -1,6 +1,7 @@
 import {useState, useEffect} from 'react';
 import {PORT, MandelbrotCoords, Functions} from '../constants.js';
 import RenderView from './RenderView.js';
+import {decimalText, addDecimal, negateDecimal} from '../decimal.js';
 
 console.log("process.env:", process.env);
 let API_URL = `http://localhost:${PORT}/api/renderFractal`;
@@ -257,6 +258,7 @@ const Form = () => {
 	const [colorized, setColorized] = useState(false);
 	const [textColor, setTextColor] = useState("black");
 	const [fetchError, setFetchError] = useState(false);
+	const [inputError, setInputError] = useState(false);
 
 	const navButtonActiveClass = "bg-blue-400 hover:bg-blue-300 w-full md:w-1/6 sm:h-2/3 "
 		+ "rounded-md font-mono outline-none p-3 flex-grow";
@@ -298,6 +300,7 @@ const Form = () => {
 			xmin: object.xmin,
 			ymax: object.ymax,
 			ymin: object.ymin,
+			exact: object.exact,
 		});
 		setFractalType(object.fractalType);
 		setFunctionToUse(object.functionToUse);
@@ -308,10 +311,19 @@ const Form = () => {
 	};
 
 	const handleSubmit = () => {
+		// Sent as strings so that high precision renders get every digit.
+		const x = decimalText(clientBounds.x);
+		const y = decimalText(clientBounds.y);
+		const zoom = decimalText(clientBounds.zoom);
+		if (x === null || y === null || zoom === null) {
+			setInputError(true);
+			return;
+		}
+		setInputError(false);
 		const clientData = {
-			x: parseFloat(clientBounds.x),
-			y: parseFloat(clientBounds.y),
-			zoom: parseFloat(clientBounds.zoom),
+			x,
+			y,
+			zoom,
 			fractalType,
 			functionToUse,
 			antiAliasing,
@@ -331,6 +343,9 @@ const Form = () => {
 			body: JSON.stringify(clientData),
 		})
 		.then(response => {
+				if (!response.ok) {
+					throw new Error(`render failed: ${response.status}`);
+				}
 				return response.json()
 			}
 		)
@@ -342,6 +357,8 @@ const Form = () => {
 				xmin: data.xmin,
 				ymax: data.ymax,
 				ymin: data.ymin,
+				// Only sent for high precision renders.
+				exact: data.exact,
 			}
 			setRenderBounds(newRenderBounds);
 
@@ -375,7 +392,18 @@ const Form = () => {
 		setClientBounds({...clientBounds, x, y});
 	};
 
+	// Deep frames place the cursor from their exact centre, which the
+	// rounded bounds cannot tell apart from its neighbours.
 	const useCursorCoords = (coords) => {
+		const exact = renderBounds.exact;
+		if (exact) {
+			setClientBounds({
+				...clientBounds,
+				x: addDecimal(exact.x, coords.dx),
+				y: addDecimal(negateDecimal(exact.y), coords.dy),
+			});
+			return;
+		}
 		setClientBounds({...clientBounds, x: coords.x, y: coords.y});
 	};
 
@@ -489,6 +517,9 @@ const Form = () => {
 			</div>
 				{loading ? <span className="mb-3">Rendering fractal...</span> : <></>}
 				{fetchError ? <span className="mb-3">Server error.</span> : <></>}
+				{inputError
+					? <span className="mb-3">Coordinates and zoom must be numbers.</span>
+					: <></>}
 				{imageStr
 					? <RenderView 
 							src={imageStr}
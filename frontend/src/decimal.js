@@ -0,0 +1,59 @@
+/* global BigInt */
+
+// Decimal strings for coordinates deeper than a JavaScript number can
+// hold. The backend takes them as they are and sends exact bounds back in
+// the same form.
+
+const DECIMAL = /^([+-]?)(\d*)\.?(\d*)(?:[eE]([+-]?\d+))?$/;
+
+// Returns value as a decimal string, "0" when it is empty, or null when
+// it is not a finite decimal number.
+export const decimalText = (value) => {
+	const text = String(value ?? "").trim();
+	if (text === "") {
+		return "0";
+	}
+	const m = DECIMAL.exec(text);
+	if (!m || (m[2] === "" && m[3] === "")) {
+		return null;
+	}
+	return text;
+};
+
+// A decimal string as a BigInt of its digits and a power of ten.
+const parse = (text) => {
+	const m = DECIMAL.exec(text);
+	return {
+		n: BigInt(m[1] + (m[2] + m[3] || "0")),
+		e: parseInt(m[4] || "0", 10) - m[3].length,
+	};
+};
+
+const pow10 = (k) => BigInt("1" + "0".repeat(k));
+
+const format = ({n, e}) => {
+	const sign = n < BigInt(0) ? "-" : "";
+	let digits = (n < BigInt(0) ? -n : n).toString();
+	if (e >= 0) {
+		return sign + digits + "0".repeat(e);
+	}
+	digits = digits.padStart(1 - e, "0");
+	const whole = digits.slice(0, digits.length + e);
+	const fraction = digits.slice(digits.length + e).replace(/0+$/, "");
+	return sign + whole + (fraction ? "." + fraction : "");
+};
+
+// Returns the decimal string a plus the number b, without rounding a.
+export const addDecimal = (a, b) => {
+	const x = parse(a);
+	const y = parse(b.toExponential());
+	const e = Math.min(x.e, y.e);
+	return format({
+		n: x.n * pow10(x.e - e) + y.n * pow10(y.e - e),
+		e,
+	});
+};
+
+export const negateDecimal = (a) => {
+	return a.startsWith("-") ? a.slice(1) : "-" + a.replace(/^\+/, "");
+};